@@ -1,24 +1,34 @@
 package main
 
 import (
+	alibabacloudproviderapis "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis"
 	alibabacloudproviderv1alpha1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis/v1alpha1"
 	alibabacloudcorev1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1"
+	awsproviderapis "github.com/cloudpilot-ai/lib/pkg/aws/karpenter-provider-aws/apis"
+	awsproviderv1 "github.com/cloudpilot-ai/lib/pkg/aws/karpenter-provider-aws/apis/v1"
+	awscorev1 "github.com/cloudpilot-ai/lib/pkg/aws/karpenter/apis/v1"
 )
 
 type RebalanceNodePoolList struct {
 	ECSNodePools []ECSNodePool `json:"ecsNodePools"`
+	EC2NodePools []EC2NodePool `json:"ec2NodePools"`
 }
 
 type RebalanceNodeClassList struct {
 	ECSNodeClasses []ECSNodeClass `json:"ecsNodeClasses"`
+	EC2NodeClasses []EC2NodeClass `json:"ec2NodeClasses"`
 }
 
+// RebalanceNodePool is the envelope the server accepts, exactly one variant is set.
 type RebalanceNodePool struct {
-	ECSNodePool *ECSNodePool `json:"ecsNodePool"`
+	ECSNodePool *ECSNodePool `json:"ecsNodePool,omitempty"`
+	EC2NodePool *EC2NodePool `json:"ec2NodePool,omitempty"`
 }
 
+// RebalanceNodeClass is the envelope the server accepts, exactly one variant is set.
 type RebalanceNodeClass struct {
-	ECSNodeClass *ECSNodeClass `json:"ecsNodeClass"`
+	ECSNodeClass *ECSNodeClass `json:"ecsNodeClass,omitempty"`
+	EC2NodeClass *EC2NodeClass `json:"ec2NodeClass,omitempty"`
 }
 
 type ECSNodePool struct {
@@ -31,3 +41,156 @@ type ECSNodeClass struct {
 	Name          string                                         `json:"name"`
 	NodeClassSpec *alibabacloudproviderv1alpha1.ECSNodeClassSpec `json:"nodeClassSpec"`
 }
+
+type EC2NodePool struct {
+	Name   string `json:"name"`
+	Enable bool   `json:"enable"`
+	// NodePoolAnnotation carries the NodePool annotations for agents up to v0.37.7.
+	NodePoolAnnotation map[string]string       `json:"nodePoolAnnotation"`
+	NodePoolSpec       *awscorev1.NodePoolSpec `json:"nodePoolSpec"`
+}
+
+type EC2NodeClass struct {
+	Name string `json:"name"`
+	// NodeClassAnnotation carries the EC2NodeClass annotations for agents up to v0.37.7.
+	NodeClassAnnotation map[string]string               `json:"nodeClassAnnotation"`
+	NodeClassSpec       *awsproviderv1.EC2NodeClassSpec `json:"nodeClassSpec"`
+}
+
+// Items flattens the per-provider lists into envelopes.
+func (l RebalanceNodePoolList) Items() []RebalanceNodePool {
+	items := make([]RebalanceNodePool, 0, len(l.ECSNodePools)+len(l.EC2NodePools))
+	for i := range l.ECSNodePools {
+		items = append(items, RebalanceNodePool{ECSNodePool: &l.ECSNodePools[i]})
+	}
+	for i := range l.EC2NodePools {
+		items = append(items, RebalanceNodePool{EC2NodePool: &l.EC2NodePools[i]})
+	}
+	return items
+}
+
+// Items flattens the per-provider lists into envelopes.
+func (l RebalanceNodeClassList) Items() []RebalanceNodeClass {
+	items := make([]RebalanceNodeClass, 0, len(l.ECSNodeClasses)+len(l.EC2NodeClasses))
+	for i := range l.ECSNodeClasses {
+		items = append(items, RebalanceNodeClass{ECSNodeClass: &l.ECSNodeClasses[i]})
+	}
+	for i := range l.EC2NodeClasses {
+		items = append(items, RebalanceNodeClass{EC2NodeClass: &l.EC2NodeClasses[i]})
+	}
+	return items
+}
+
+func (p RebalanceNodePool) GetName() string {
+	switch {
+	case p.ECSNodePool != nil:
+		return p.ECSNodePool.Name
+	case p.EC2NodePool != nil:
+		return p.EC2NodePool.Name
+	}
+	return ""
+}
+
+func (p RebalanceNodePool) Enabled() bool {
+	switch {
+	case p.ECSNodePool != nil:
+		return p.ECSNodePool.Enable
+	case p.EC2NodePool != nil:
+		return p.EC2NodePool.Enable
+	}
+	return false
+}
+
+// WithEnable returns a copy of p with the Enable flag set to enable.
+func (p RebalanceNodePool) WithEnable(enable bool) RebalanceNodePool {
+	switch {
+	case p.ECSNodePool != nil:
+		np := *p.ECSNodePool
+		np.Enable = enable
+		return RebalanceNodePool{ECSNodePool: &np}
+	case p.EC2NodePool != nil:
+		np := *p.EC2NodePool
+		np.Enable = enable
+		return RebalanceNodePool{EC2NodePool: &np}
+	}
+	return p
+}
+
+// Spec returns the provider-specific NodePoolSpec, or nil.
+func (p RebalanceNodePool) Spec() any {
+	switch {
+	case p.ECSNodePool != nil:
+		return p.ECSNodePool.NodePoolSpec
+	case p.EC2NodePool != nil:
+		return p.EC2NodePool.NodePoolSpec
+	}
+	return nil
+}
+
+// object returns the set variant, used to compare envelopes field by field.
+func (p RebalanceNodePool) object() any {
+	if p.EC2NodePool != nil {
+		return p.EC2NodePool
+	}
+	return p.ECSNodePool
+}
+
+func (c RebalanceNodeClass) GetName() string {
+	switch {
+	case c.ECSNodeClass != nil:
+		return c.ECSNodeClass.Name
+	case c.EC2NodeClass != nil:
+		return c.EC2NodeClass.Name
+	}
+	return ""
+}
+
+func (c RebalanceNodeClass) Kind() string {
+	if c.EC2NodeClass != nil {
+		return "EC2NodeClass"
+	}
+	return "ECSNodeClass"
+}
+
+// Spec returns the provider-specific NodeClassSpec, or nil.
+func (c RebalanceNodeClass) Spec() any {
+	switch {
+	case c.ECSNodeClass != nil:
+		return c.ECSNodeClass.NodeClassSpec
+	case c.EC2NodeClass != nil:
+		return c.EC2NodeClass.NodeClassSpec
+	}
+	return nil
+}
+
+// object returns the set variant, used to compare envelopes field by field.
+func (c RebalanceNodeClass) object() any {
+	if c.EC2NodeClass != nil {
+		return c.EC2NodeClass
+	}
+	return c.ECSNodeClass
+}
+
+// NodeClassRef returns the group, kind and name the NodePool template points
+// to, and false if it has no nodeClassRef.
+func (p RebalanceNodePool) NodeClassRef() (group, kind, name string, ok bool) {
+	switch {
+	case p.ECSNodePool != nil && p.ECSNodePool.NodePoolSpec != nil:
+		if ref := p.ECSNodePool.NodePoolSpec.Template.Spec.NodeClassRef; ref != nil {
+			return ref.Group, ref.Kind, ref.Name, true
+		}
+	case p.EC2NodePool != nil && p.EC2NodePool.NodePoolSpec != nil:
+		if ref := p.EC2NodePool.NodePoolSpec.Template.Spec.NodeClassRef; ref != nil {
+			return ref.Group, ref.Kind, ref.Name, true
+		}
+	}
+	return "", "", "", false
+}
+
+// nodeClassGroupKind is what a NodePool of this variant must reference.
+func (p RebalanceNodePool) nodeClassGroupKind() (group, kind string) {
+	if p.EC2NodePool != nil {
+		return awsproviderapis.Group, "EC2NodeClass"
+	}
+	return alibabacloudproviderapis.Group, "ECSNodeClass"
+}
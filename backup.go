@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// serverSnapshot is an on-disk copy of the server-side rebalance config of a cluster.
+type serverSnapshot struct {
+	ClusterID   string               `json:"clusterID"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	NodePools   []RebalanceNodePool  `json:"nodePools"`
+	NodeClasses []RebalanceNodeClass `json:"nodeClasses"`
+}
+
+func newServerSnapshot(clusterID string, nodepools RebalanceNodePoolList, nodeclasses RebalanceNodeClassList) serverSnapshot {
+	return serverSnapshot{
+		ClusterID:   clusterID,
+		CreatedAt:   time.Now().UTC(),
+		NodePools:   nodepools.Items(),
+		NodeClasses: nodeclasses.Items(),
+	}
+}
+
+// writeBackup stores the snapshot, including each pool's Enable flag, in a
+// timestamped file under dir and returns its path.
+func writeBackup(dir string, snapshot serverSnapshot) (string, error) {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal backup: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create backup dir: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("ack_migrate-backup-%s-%s.json", snapshot.ClusterID, snapshot.CreatedAt.Format("20060102T150405Z")))
+	if err := writeFileExclusive(path, data); err != nil {
+		return "", fmt.Errorf("write backup file: %w", err)
+	}
+	return path, nil
+}
+
+func readSnapshot(path string) (serverSnapshot, error) {
+	var snapshot serverSnapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("decode snapshot %s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// restoreSteps re-applies every object of the snapshot, NodeClasses first so
+// that the NodePools referencing them are valid. Objects that exist on the
+// server but not in the snapshot are left untouched.
+func restoreSteps(c *Client, snapshot serverSnapshot) []step {
+	return uploadSteps(c, snapshot.NodeClasses, snapshot.NodePools)
+}
+
+// lists splits the snapshot back into the per-provider lists the server returns.
+func (s serverSnapshot) lists() (RebalanceNodePoolList, RebalanceNodeClassList) {
+	var pools RebalanceNodePoolList
+	for _, np := range s.NodePools {
+		switch {
+		case np.ECSNodePool != nil:
+			pools.ECSNodePools = append(pools.ECSNodePools, *np.ECSNodePool)
+		case np.EC2NodePool != nil:
+			pools.EC2NodePools = append(pools.EC2NodePools, *np.EC2NodePool)
+		}
+	}
+	var classes RebalanceNodeClassList
+	for _, nc := range s.NodeClasses {
+		switch {
+		case nc.ECSNodeClass != nil:
+			classes.ECSNodeClasses = append(classes.ECSNodeClasses, *nc.ECSNodeClass)
+		case nc.EC2NodeClass != nil:
+			classes.EC2NodeClasses = append(classes.EC2NodeClasses, *nc.EC2NodeClass)
+		}
+	}
+	return pools, classes
+}
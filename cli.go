@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/values"
+	"k8s.io/apimachinery/pkg/labels"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// command is one subcommand of the CLI. Names of grouped commands contain a
+// space, e.g. "server list".
+type command struct {
+	name    string
+	aliases []string
+	summary string
+	help    string
+	// flags registers the flags the command takes on its own FlagSet.
+	flags func(fs *flag.FlagSet, o *options)
+	run   func(ctx context.Context, o *options)
+}
+
+// options holds every flag value; each command registers only the flags it
+// uses, the rest stay at their zero value.
+type options struct {
+	clusterID    string
+	api          ClientOptions
+	kubeconfig   string
+	kubeContext  string
+	provider     string
+	backupDir    string
+	from         string
+	out          string
+	prune        bool
+	selector     string
+	nodepools    stringsFlag
+	nodeclasses  stringsFlag
+	exclude      stringsFlag
+	inclOrphans  bool
+	skipOrphans  bool
+	yesDelete    bool
+	yesUpload    bool
+	confirmToken string
+	timeout      time.Duration
+	concurrency  int
+	resume       string
+	noRollback   bool
+	skipVerify   bool
+	enableMode   string
+	enableOver   stringsFlag
+	namespaces   stringsFlag
+	acceptConv   bool
+}
+
+func serverFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.clusterID, "clusterid", "", "CloudPilot AI cluster id (required)")
+	fs.StringVar(&o.api.Endpoint, "api-endpoint", os.Getenv(values.CloudPilotAPIEndpointEnv), "CloudPilot AI API endpoint (env "+values.CloudPilotAPIEndpointEnv+", default "+defaultAPIEndpoint+")")
+	fs.StringVar(&o.api.CABundle, "api-ca-bundle", "", "PEM file with CA certificates to trust for the API, in addition to the system roots")
+	fs.StringVar(&o.api.CertFile, "api-client-cert", "", "client certificate for mTLS to the API, requires --api-client-key")
+	fs.StringVar(&o.api.KeyFile, "api-client-key", "", "client key for mTLS to the API")
+	fs.StringVar(&o.api.Proxy, "api-proxy", "", "proxy URL for the API (default: HTTPS_PROXY/NO_PROXY from the environment)")
+	fs.BoolVar(&o.api.InsecureSkipVerify, "insecure-skip-verify", false, "do NOT verify the API's TLS certificate, lab use only")
+	fs.DurationVar(&o.api.RequestTimeout, "request-timeout", 30*time.Second, "timeout of each API request attempt, 0 for none")
+	fs.IntVar(&o.api.RetryMax, "api-retries", 4, "retries of a failed API request; only connection errors, 429 and 5xx are retried, POSTs only when idempotent")
+	fs.DurationVar(&o.api.RetryWaitMin, "api-retry-wait-min", time.Second, "shortest backoff between API retries")
+	fs.DurationVar(&o.api.RetryWaitMax, "api-retry-wait-max", 30*time.Second, "longest backoff between API retries, a Retry-After from the server is honored as it is")
+	fs.Float64Var(&o.api.RetryJitter, "api-retry-jitter", 0.2, "spread each backoff randomly by up to this fraction, 0 to disable")
+}
+
+func kubeFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "kubeconfig file (default: the KUBECONFIG files merged, then ~/.kube/config, then the in-cluster service account)")
+	fs.StringVar(&o.kubeContext, "context", "", "kubeconfig context to use (default: the current context)")
+}
+
+func clusterFlags(fs *flag.FlagSet, o *options) {
+	kubeFlags(fs, o)
+	fs.StringVar(&o.provider, "provider", "", "cloud provider of the cluster: alibabacloud or aws (default: detected from the installed CRDs)")
+}
+
+func backupFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.backupDir, "backup-dir", ".", "directory for the server-side backup and the journal written before anything is changed")
+	fs.StringVar(&o.resume, "resume", "", "journal of an interrupted or failed run of the same command; steps it has as done are not repeated")
+	fs.BoolVar(&o.noRollback, "no-rollback", false, "on failure keep what was done instead of rolling back, to finish later with --resume")
+	fs.BoolVar(&o.skipVerify, "skip-verify", false, "don't list the server again after the run to check it stores what was sent")
+}
+
+// enableFlags registers the flags that decide the Enable flag of uploaded
+// NodePools.
+func enableFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.enableMode, "enable", "", "Enable flag of uploaded NodePools: all, none, or preserve the server's flag (new pools start disabled); by default cluster NodePools are enabled and bundles keep theirs")
+	fs.Var(&o.enableOver, "enable-override", "name=true|false for a single NodePool, wins over --enable, repeatable or comma-separated")
+}
+
+// selectionFlags registers the name filters, and --selector when the command
+// reads cluster objects, which are the only ones with labels.
+func selectionFlags(fs *flag.FlagSet, o *options, withLabels bool) {
+	fs.Var(&o.nodepools, "nodepool", "only NodePools matching these name globs, repeatable or comma-separated")
+	fs.Var(&o.nodeclasses, "nodeclass", "only NodeClasses matching these name globs, repeatable or comma-separated; NodeClasses referenced by selected NodePools are always included")
+	fs.Var(&o.exclude, "exclude", "leave out NodePools and NodeClasses matching these name globs, repeatable or comma-separated")
+	if withLabels {
+		fs.StringVar(&o.selector, "selector", "", "only cluster objects matching this label selector, e.g. team=a,env!=dev")
+	}
+}
+
+func orphanFlags(fs *flag.FlagSet, o *options) {
+	fs.BoolVar(&o.inclOrphans, "include-orphans", false, "upload NodeClasses that no NodePool references")
+	fs.BoolVar(&o.skipOrphans, "skip-orphans", false, "leave out NodeClasses that no NodePool references")
+}
+
+// conversionFlags registers the flags of the commands that read v1beta1
+// NodePools to upload them.
+func conversionFlags(fs *flag.FlagSet, o *options) {
+	fs.BoolVar(&o.acceptConv, "accept-conversion-issues", false, "go on when v1beta1 NodePool fields have no place in v1 and would be lost, e.g. kubelet, instead of stopping with exit code 3")
+}
+
+// changeFlags registers the flags of the commands that change the server.
+func changeFlags(fs *flag.FlagSet, o *options) {
+	fs.BoolVar(&o.yesDelete, "yes-delete", false, "approve deleting server-side objects without a prompt")
+	fs.BoolVar(&o.yesUpload, "yes-upload", false, "approve uploading objects to the server without a prompt")
+	fs.StringVar(&o.confirmToken, "confirm", "", "approve every change without a prompt, must equal --clusterid")
+	fs.IntVar(&o.concurrency, "concurrency", 1, "objects changed in parallel; NodePools are still deleted before their NodeClasses and uploaded after them")
+}
+
+// serverClient builds the CloudPilot AI client from --clusterid, the --api-*
+// flags and CLOUDPILOT_API_KEY.
+func (o *options) serverClient() *Client {
+	if o.clusterID == "" {
+		fatalf(exitError, "--clusterid is required")
+	}
+	ak := os.Getenv(values.CloudPilotAPIKeyEnv)
+	if ak == "" {
+		fatalf(exitError, "%s env is empty", values.CloudPilotAPIKeyEnv)
+	}
+	c, err := NewCloudPilotClient(ak, o.clusterID, o.api)
+	if err != nil {
+		fatalf(exitError, "failed to create CloudPilot AI client: %v", err)
+	}
+	if o.api.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+		fmt.Fprintf(os.Stderr, "WARNING: --insecure-skip-verify is set, the TLS certificate of %s is NOT verified.\n", c.API)
+		fmt.Fprintln(os.Stderr, "Anyone on the network path can read the API key and change what is uploaded.")
+		fmt.Fprintln(os.Stderr, "Use this in a lab only, never against a production cluster.")
+		fmt.Fprintln(os.Stderr, "!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+	}
+	klog.Infof("using CloudPilot AI API %s", c.API)
+	return c
+}
+
+// kubeTarget is the cluster the kube client talks to.
+type kubeTarget struct {
+	Server string
+	// Context is empty for the in-cluster service-account config.
+	Context string
+}
+
+func (t kubeTarget) String() string {
+	if t.Context == "" {
+		return t.Server + " (in-cluster config)"
+	}
+	return fmt.Sprintf("%s (context %q)", t.Server, t.Context)
+}
+
+// restConfig resolves the cluster with the standard kubeconfig loading rules,
+// --kubeconfig and --context, falling back to the in-cluster config.
+func (o *options) restConfig() (*rest.Config, kubeTarget) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: o.kubeContext})
+	cfg, err := cc.ClientConfig()
+	if err != nil {
+		fatalf(exitError, "failed to create config: %v", err)
+	}
+	// the discovery behind the client's REST mapping doesn't take a context,
+	// so --timeout also bounds each request
+	if o.timeout > 0 {
+		cfg.Timeout = o.timeout
+	}
+	target := kubeTarget{Server: cfg.Host, Context: o.kubeContext}
+	if target.Context == "" {
+		if raw, err := cc.RawConfig(); err == nil {
+			target.Context = raw.CurrentContext
+		}
+	}
+	klog.Infof("using cluster %s", target)
+	return cfg, target
+}
+
+// kubeClient builds the controller-runtime client of the Karpenter objects. It
+// returns the provider too, detected from the installed CRDs unless --provider
+// is set.
+func (o *options) kubeClient() (client.Client, string, kubeTarget) {
+	cfg, target := o.restConfig()
+	provider := o.provider
+	var err error
+	if provider == "" {
+		provider, err = detectProvider(cfg)
+		if err != nil {
+			fatalf(exitError, "failed to detect provider: %v", err)
+		}
+		klog.Infof("detected provider: %s", provider)
+	}
+	kubeScheme, err := newScheme(provider)
+	if err != nil {
+		fatalf(exitError, "failed to create scheme: %v", err)
+	}
+
+	kubeClient, err := client.New(cfg, client.Options{Scheme: kubeScheme})
+	if err != nil {
+		fatalf(exitError, "failed to create client: %v", err)
+	}
+	return kubeClient, provider, target
+}
+
+// workloadsClient builds a client of the built-in kinds only, for commands
+// that don't read Karpenter objects and so need no provider.
+func (o *options) workloadsClient() client.Client {
+	cfg, _ := o.restConfig()
+	kubeClient, err := client.New(cfg, client.Options{Scheme: clientgoscheme.Scheme})
+	if err != nil {
+		fatalf(exitError, "failed to create client: %v", err)
+	}
+	return kubeClient
+}
+
+func (o *options) selection() selection {
+	mustGlobs("nodepool", o.nodepools)
+	mustGlobs("nodeclass", o.nodeclasses)
+	mustGlobs("exclude", o.exclude)
+	return selection{NodePools: o.nodepools, NodeClasses: o.nodeclasses, Exclude: o.exclude, Selector: o.parseSelector()}
+}
+
+// parseSelector parses --selector, nil when it is not given.
+func (o *options) parseSelector() labels.Selector {
+	if o.selector == "" {
+		return nil
+	}
+	selector, err := labels.Parse(o.selector)
+	if err != nil {
+		fatalf(exitError, "invalid --selector: %v", err)
+	}
+	return selector
+}
+
+// mustGlobs stops on a --name glob that doesn't parse, which would otherwise
+// match nothing; an --exclude that excludes nothing is the worst case.
+func mustGlobs(name string, globs []string) {
+	if err := checkGlobs(globs); err != nil {
+		fatalf(exitError, "invalid --%s glob %v", name, err)
+	}
+}
+
+// setEnableFlags are the flags of enable and disable.
+func setEnableFlags(fs *flag.FlagSet, o *options) {
+	serverFlags(fs, o)
+	backupFlags(fs, o)
+	fs.Var(&o.nodepools, "nodepool", "NodePools matching these name globs (required), repeatable or comma-separated; '*' for all")
+	fs.Var(&o.exclude, "exclude", "leave out NodePools matching these name globs, repeatable or comma-separated")
+	changeFlags(fs, o)
+}
+
+// enableSelection is the selection of enable and disable, which never act on
+// every pool without an explicit --nodepool.
+func (o *options) enableSelection() selection {
+	if len(o.nodepools) == 0 {
+		fatalf(exitError, "--nodepool is required, pass --nodepool='*' for every NodePool")
+	}
+	mustGlobs("nodepool", o.nodepools)
+	mustGlobs("exclude", o.exclude)
+	return selection{NodePools: o.nodepools, Exclude: o.exclude}
+}
+
+// workloadFilter is the filter of workloads scan, from --namespace,
+// --exclude and --selector.
+func (o *options) workloadFilter() workloadFilter {
+	mustGlobs("namespace", o.namespaces)
+	mustGlobs("exclude", o.exclude)
+	return workloadFilter{Namespaces: o.namespaces, Exclude: o.exclude, Selector: o.parseSelector()}
+}
+
+func (o *options) enablePolicy() enablePolicy {
+	p, err := parseEnablePolicy(o.enableMode, o.enableOver)
+	if err != nil {
+		fatalf(exitError, "%v", err)
+	}
+	return p
+}
+
+func (o *options) orphanPolicy() orphanPolicy {
+	if o.inclOrphans && o.skipOrphans {
+		fatalf(exitError, "--include-orphans and --skip-orphans are mutually exclusive")
+	}
+	return orphanPolicy{include: o.inclOrphans, skip: o.skipOrphans}
+}
+
+func (o *options) confirmation() confirmation {
+	if o.confirmToken != "" && o.confirmToken != o.clusterID {
+		fatalf(exitError, "--confirm=%s does not match --clusterid=%s", o.confirmToken, o.clusterID)
+	}
+	return confirmation{ClusterID: o.clusterID, Token: o.confirmToken, YesDelete: o.yesDelete, YesUpload: o.yesUpload}
+}
+
+// changeOptions are the flags every command that changes the server shares.
+type changeOptions struct {
+	BackupDir   string
+	Confirm     confirmation
+	Concurrency int
+	Resume      string
+	NoRollback  bool
+	SkipVerify  bool
+}
+
+func (o *options) changeOptions() changeOptions {
+	return changeOptions{BackupDir: o.backupDir, Confirm: o.confirmation(), Concurrency: o.concurrency, Resume: o.resume, NoRollback: o.noRollback, SkipVerify: o.skipVerify}
+}
+
+// findCommand matches the leading args against the command names and aliases
+// and returns the command with the remaining args.
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		cmd := &commands[i]
+		for _, name := range append([]string{cmd.name}, cmd.aliases...) {
+			words := strings.Fields(name)
+			if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == name {
+				return cmd, args[len(words):]
+			}
+		}
+	}
+	return nil, args
+}
+
+// runCommand parses the command's own flags and runs it.
+func runCommand(cmd *command, args []string) {
+	var o options
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	if cmd.flags != nil {
+		cmd.flags(fs, &o)
+	}
+	fs.DurationVar(&o.timeout, "timeout", 0, "deadline for the whole command, e.g. 10m, 0 for none; a rollback still runs after it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n%s\n\nFlags:\n", os.Args[0], cmd.name, cmd.help)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(exitError)
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		fatalf(exitError, "unexpected arguments: %v", fs.Args())
+	}
+	ctx := context.Background()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	cmd.run(ctx, &o)
+}
+
+func usage() {
+	out := os.Stderr
+	fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		name := cmd.name
+		if len(cmd.aliases) > 0 {
+			name += " (" + strings.Join(cmd.aliases, ", ") + ")"
+		}
+		fmt.Fprintf(out, "  %-18s %s\n", name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+	fmt.Fprintln(out, "Exit codes: 1 error, 2 aborted, 3 validation failed, 4 delete failed, 5 upload failed, 6 verification failed, 8 not confirmed without a terminal")
+}
@@ -3,10 +3,17 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/cloudpilot-client/api"
 	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/utils/leveledlogger"
@@ -14,6 +21,8 @@ import (
 	"k8s.io/klog"
 )
 
+const defaultAPIEndpoint = "https://api.cloudpilot.ai"
+
 type Client struct {
 	API       string
 	APIKEY    string
@@ -21,47 +30,99 @@ type Client struct {
 	rc        *retryablehttp.Client
 }
 
-func NewCloudPilotClient(apiKey, clusterID string) *Client {
+// ClientOptions configures how the API is reached, the zero value talks to
+// defaultAPIEndpoint with the system roots and the proxy from the environment.
+type ClientOptions struct {
+	Endpoint string
+	// CABundle is a PEM file trusted in addition to the system roots.
+	CABundle string
+	// CertFile and KeyFile are the client certificate for mTLS.
+	CertFile string
+	KeyFile  string
+	// Proxy overrides HTTPS_PROXY/HTTP_PROXY/NO_PROXY.
+	Proxy              string
+	InsecureSkipVerify bool
+	// RequestTimeout bounds each HTTP attempt, 0 means no limit.
+	RequestTimeout time.Duration
+	// RetryMax is the number of retries after the first attempt.
+	RetryMax int
+	// RetryWaitMin and RetryWaitMax bound the exponential backoff between
+	// attempts, RetryJitter spreads it by up to that fraction.
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+	RetryJitter  float64
+}
+
+func NewCloudPilotClient(apiKey, clusterID string, opts ClientOptions) (*Client, error) {
+	endpoint := strings.TrimSuffix(opts.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = defaultAPIEndpoint
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid API endpoint %q, want http(s)://host[:port]", opts.Endpoint)
+	}
+
+	rc, err := newRetryClient(opts)
+	if err != nil {
+		return nil, err
+	}
 	return &Client{
-		API:       "https://api.cloudpilot.ai",
+		API:       endpoint,
 		APIKEY:    apiKey,
 		ClusterID: clusterID,
-	}
+		rc:        rc,
+	}, nil
 }
 
-func (c *Client) DeleteClusterRebalanceNodePool(nodePoolName string) error {
+// DeleteClusterRebalanceNodePool deletes the NodePool, one that is already gone counts as deleted.
+func (c *Client) DeleteClusterRebalanceNodePool(ctx context.Context, nodePoolName string) error {
 	url := fmt.Sprintf("%s/api/v1/rebalance/clusters/%s/nodepools/%s", c.API, c.ClusterID, nodePoolName)
-	return doJSONNoData(c, http.MethodDelete, url, nil)
+	err := doJSONNoData(ctx, c, http.MethodDelete, url, nil)
+	if isNotFound(err) {
+		klog.Infof("nodepool %s is already deleted", nodePoolName)
+		return nil
+	}
+	return err
 }
 
-func (c *Client) DeleteClusterRebalanceNodeClass(nodeClassName string) error {
+// DeleteClusterRebalanceNodeClass deletes the NodeClass, one that is already gone counts as deleted.
+func (c *Client) DeleteClusterRebalanceNodeClass(ctx context.Context, nodeClassName string) error {
 	url := fmt.Sprintf("%s/api/v1/rebalance/clusters/%s/nodeclasses/%s", c.API, c.ClusterID, nodeClassName)
-	return doJSONNoData(c, http.MethodDelete, url, nil)
+	err := doJSONNoData(ctx, c, http.MethodDelete, url, nil)
+	if isNotFound(err) {
+		klog.Infof("nodeclass %s is already deleted", nodeClassName)
+		return nil
+	}
+	return err
 }
 
-func (c *Client) ListClusterRebalanceNodePools() (RebalanceNodePoolList, error) {
+func (c *Client) ListClusterRebalanceNodePools(ctx context.Context) (RebalanceNodePoolList, error) {
 	url := fmt.Sprintf("%s/api/v1/rebalance/clusters/%s/nodepools", c.API, c.ClusterID)
-	return doJSON[RebalanceNodePoolList](c, http.MethodGet, url, nil)
+	return doJSON[RebalanceNodePoolList](ctx, c, http.MethodGet, url, nil)
 }
 
-func (c *Client) ListClusterRebalanceNodeClasses() (RebalanceNodeClassList, error) {
+func (c *Client) ListClusterRebalanceNodeClasses(ctx context.Context) (RebalanceNodeClassList, error) {
 	url := fmt.Sprintf("%s/api/v1/rebalance/clusters/%s/nodeclasses", c.API, c.ClusterID)
-	return doJSON[RebalanceNodeClassList](c, http.MethodGet, url, nil)
+	return doJSON[RebalanceNodeClassList](ctx, c, http.MethodGet, url, nil)
 }
 
-func (c *Client) ApplyNodePool(nodepool RebalanceNodePool) error {
+// ApplyNodePool upserts the NodePool by name, so it is safe to retry.
+func (c *Client) ApplyNodePool(ctx context.Context, nodepool RebalanceNodePool) error {
+	ctx = withIdempotent(ctx)
 	url := fmt.Sprintf("%s/api/v1/rebalance/clusters/%s/nodepools", c.API, c.ClusterID)
-	if err := doJSONNoData(c, http.MethodPost, url, nodepool); err != nil {
-		klog.Errorf("ApplyNodePool %s failed: %v", nodepool.ECSNodePool.Name, err)
+	if err := doJSONNoData(ctx, c, http.MethodPost, url, nodepool); err != nil {
+		klog.Errorf("ApplyNodePool %s failed: %v", nodepool.GetName(), err)
 		return err
 	}
 	return nil
 }
 
-func (c *Client) ApplyNodeClass(nodeclass RebalanceNodeClass) error {
+// ApplyNodeClass upserts the NodeClass by name, so it is safe to retry.
+func (c *Client) ApplyNodeClass(ctx context.Context, nodeclass RebalanceNodeClass) error {
+	ctx = withIdempotent(ctx)
 	url := fmt.Sprintf("%s/api/v1/rebalance/clusters/%s/nodeclasses", c.API, c.ClusterID)
-	if err := doJSONNoData(c, http.MethodPost, url, nodeclass); err != nil {
-		klog.Errorf("ApplyNodeClass %s failed: %v", nodeclass.ECSNodeClass.Name, err)
+	if err := doJSONNoData(ctx, c, http.MethodPost, url, nodeclass); err != nil {
+		klog.Errorf("ApplyNodeClass %s failed: %v", nodeclass.GetName(), err)
 		return err
 	}
 	return nil
@@ -70,16 +131,16 @@ func (c *Client) ApplyNodeClass(nodeclass RebalanceNodeClass) error {
 // --------------------------Utils----------------------------
 
 // doJSONNoData calls doJSON[struct{}] when you don't care about Data.
-func doJSONNoData(c *Client, method, url string, payload any) error {
-	_, err := doJSON[struct{}](c, method, url, payload)
+func doJSONNoData(ctx context.Context, c *Client, method, url string, payload any) error {
+	_, err := doJSON[struct{}](ctx, c, method, url, payload)
 	return err
 }
 
 // Generic JSON std-envelope request returning Data as T.
-func doJSON[T any](c *Client, method, url string, payload any) (T, error) {
+func doJSON[T any](ctx context.Context, c *Client, method, url string, payload any) (T, error) {
 	var zero T
 
-	resp, err := c.request(method, url, payload)
+	resp, err := c.request(ctx, method, url, payload)
 	if err != nil {
 		klog.Errorf("HTTP request failed, method(%s) url(%s), err: %v", method, url, err)
 		return zero, err
@@ -92,7 +153,7 @@ func doJSON[T any](c *Client, method, url string, payload any) (T, error) {
 		// If server returned non-200 + non-JSON body, prefer status
 		if resp.StatusCode != http.StatusOK {
 			klog.Errorf("Server error (non-JSON), method(%s) url(%s): %s", method, url, resp.Status)
-			return zero, fmt.Errorf("server error: %s", resp.Status)
+			return zero, &APIError{Method: method, URL: url, StatusCode: resp.StatusCode}
 		}
 		klog.Errorf("Decode response body failed, method(%s) url(%s), err: %v", method, url, err)
 		return zero, err
@@ -100,12 +161,11 @@ func doJSON[T any](c *Client, method, url string, payload any) (T, error) {
 
 	// Non-200 -> use server message if present
 	if resp.StatusCode != http.StatusOK {
-		msg := stdResp.Message
-		if msg == "" {
-			msg = resp.Status
+		apiErr := &APIError{Method: method, URL: url, StatusCode: resp.StatusCode, Code: stdResp.Code, Message: stdResp.Message}
+		if resp.StatusCode != http.StatusNotFound || method != http.MethodDelete {
+			klog.Errorf("Server error: %v", apiErr)
 		}
-		klog.Errorf("Server error, method(%s) url(%s): %s", method, url, msg)
-		return zero, fmt.Errorf("server error: %s", msg)
+		return zero, apiErr
 	}
 
 	// Marshal stdResp.Data back to JSON then into T (robust to interface{} shape)
@@ -128,7 +188,7 @@ func doJSON[T any](c *Client, method, url string, payload any) (T, error) {
 // request builds and executes an HTTP request.
 // If reqBody is []byte or json.RawMessage, it is sent as-is (no re-marshal).
 // Otherwise, reqBody is JSON-marshaled.
-func (c *Client) request(method string, url string, reqBody any) (*http.Response, error) {
+func (c *Client) request(ctx context.Context, method string, url string, reqBody any) (*http.Response, error) {
 	var (
 		httpReq *retryablehttp.Request
 		err     error
@@ -136,12 +196,12 @@ func (c *Client) request(method string, url string, reqBody any) (*http.Response
 
 	switch b := reqBody.(type) {
 	case nil:
-		httpReq, err = c.newHTTPReq(method, url, nil)
+		httpReq, err = c.newHTTPReq(ctx, method, url, nil)
 	case []byte:
-		httpReq, err = c.newHTTPReq(method, url, b)
+		httpReq, err = c.newHTTPReq(ctx, method, url, b)
 		httpReq.Header.Set("Content-Type", "application/json")
 	case json.RawMessage:
-		httpReq, err = c.newHTTPReq(method, url, b)
+		httpReq, err = c.newHTTPReq(ctx, method, url, b)
 		httpReq.Header.Set("Content-Type", "application/json")
 	default:
 		reqBodyJSON, mErr := json.Marshal(reqBody)
@@ -149,7 +209,7 @@ func (c *Client) request(method string, url string, reqBody any) (*http.Response
 			klog.Errorf("Failed to marshal request body, method(%s) url(%s): %v", method, url, mErr)
 			return nil, mErr
 		}
-		httpReq, err = c.newHTTPReq(method, url, reqBodyJSON)
+		httpReq, err = c.newHTTPReq(ctx, method, url, reqBodyJSON)
 		httpReq.Header.Set("Content-Type", "application/json")
 	}
 	if err != nil {
@@ -167,7 +227,7 @@ func (c *Client) request(method string, url string, reqBody any) (*http.Response
 }
 
 // requestData sends gzipped []byte and transparently ungzips response if needed.
-func (c *Client) requestData(method string, url string, data []byte) (*http.Response, error) {
+func (c *Client) requestData(ctx context.Context, method string, url string, data []byte) (*http.Response, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("data is empty")
 	}
@@ -183,7 +243,7 @@ func (c *Client) requestData(method string, url string, data []byte) (*http.Resp
 		return nil, err
 	}
 
-	httpReq, err := c.newHTTPReq(method, url, compressed.Bytes())
+	httpReq, err := c.newHTTPReq(ctx, method, url, compressed.Bytes())
 	if err != nil {
 		klog.Errorf("Failed to create http request, method(%s) url(%s): %v", method, url, err)
 		return nil, err
@@ -222,8 +282,11 @@ func (c *Client) requestData(method string, url string, data []byte) (*http.Resp
 }
 
 // Build the request with common headers
-func (c *Client) newHTTPReq(method, url string, body []byte) (*retryablehttp.Request, error) {
-	httpReq, err := retryablehttp.NewRequest(method, url, body)
+func (c *Client) newHTTPReq(ctx context.Context, method, url string, body []byte) (*retryablehttp.Request, error) {
+	if method == http.MethodGet || method == http.MethodDelete {
+		ctx = withIdempotent(ctx)
+	}
+	httpReq, err := retryablehttp.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		klog.Errorf("Failed to create http request: %v", err)
 		return nil, err
@@ -241,3 +304,69 @@ func (c *Client) retryClient() *retryablehttp.Client {
 	c.rc = rc
 	return rc
 }
+
+// newRetryClient applies the TLS and proxy options to the pooled transport of
+// a default retryablehttp client.
+func newRetryClient(opts ClientOptions) (*retryablehttp.Client, error) {
+	rc := retryablehttp.NewClient()
+	rc.Logger = leveledlogger.NewKlogLeveledLogger()
+	rc.HTTPClient.Timeout = opts.RequestTimeout
+	rc.RetryMax = opts.RetryMax
+	if opts.RetryWaitMin > 0 {
+		rc.RetryWaitMin = opts.RetryWaitMin
+	}
+	if opts.RetryWaitMax > 0 {
+		rc.RetryWaitMax = opts.RetryWaitMax
+	}
+	if opts.RetryMax < 0 || opts.RetryJitter < 0 || opts.RetryJitter > 1 {
+		return nil, fmt.Errorf("retries must be >= 0 and jitter between 0 and 1")
+	}
+	if rc.RetryWaitMax < rc.RetryWaitMin {
+		return nil, fmt.Errorf("retry backoff max %s is below min %s", rc.RetryWaitMax, rc.RetryWaitMin)
+	}
+	rc.CheckRetry = checkRetry
+	rc.Backoff = jitterBackoff(opts.RetryJitter)
+	rc.RequestLogHook = logRetry(rc.RetryMax + 1)
+	// Hand the last response to doJSON, so it becomes an APIError
+	rc.ErrorHandler = retryablehttp.PassthroughErrorHandler
+	transport, ok := rc.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected transport %T", rc.HTTPClient.Transport)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", opts.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return rc, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient talks to a test server that answers every request with
+// handler.
+func newTestClient(t *testing.T, opts ClientOptions, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	opts.Endpoint = srv.URL
+	opts.RetryWaitMin, opts.RetryWaitMax = time.Millisecond, time.Millisecond
+	c, err := NewCloudPilotClient("k", "c1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantCode    int
+		wantMessage string
+		wantReason  string
+	}{
+		{
+			name:        "envelope",
+			status:      http.StatusUnprocessableEntity,
+			body:        `{"code":42,"message":"spec.weight must be positive"}`,
+			wantCode:    42,
+			wantMessage: "spec.weight must be positive",
+			wantReason:  "rejected as invalid",
+		},
+		{
+			name:       "not JSON",
+			status:     http.StatusBadGateway,
+			body:       "<html>bad gateway</html>",
+			wantReason: "server error",
+		},
+		{
+			name:        "unauthorized",
+			status:      http.StatusUnauthorized,
+			body:        `{"code":401,"message":"invalid api key"}`,
+			wantCode:    401,
+			wantMessage: "invalid api key",
+			wantReason:  "authentication failed",
+		},
+		{
+			name:       "not found",
+			status:     http.StatusNotFound,
+			body:       `{}`,
+			wantReason: "not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, ClientOptions{}, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+			_, err := c.ListClusterRebalanceNodePools(context.Background())
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.wantCode || apiErr.Message != tt.wantMessage {
+				t.Errorf("got %+v, want status %d, code %d, message %q", apiErr, tt.status, tt.wantCode, tt.wantMessage)
+			}
+			if apiErr.Reason() != tt.wantReason {
+				t.Errorf("reason = %q, want %q", apiErr.Reason(), tt.wantReason)
+			}
+			if got, want := isNotFound(err), tt.status == http.StatusNotFound; got != want {
+				t.Errorf("isNotFound = %t, want %t", got, want)
+			}
+			if tt.wantMessage != "" && !strings.Contains(err.Error(), tt.wantMessage) {
+				t.Errorf("error %q doesn't carry the server message", err)
+			}
+		})
+	}
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	c := newTestClient(t, ClientOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code":404,"message":"nodepool not found"}`))
+	})
+	if err := c.DeleteClusterRebalanceNodePool(context.Background(), "a"); err != nil {
+		t.Errorf("nodepool: got %v, want it to count as deleted", err)
+	}
+	if err := c.DeleteClusterRebalanceNodeClass(context.Background(), "x"); err != nil {
+		t.Errorf("nodeclass: got %v, want it to count as deleted", err)
+	}
+}
+
+// Once the retries are used up, the last response still reaches doJSON
+// instead of retryablehttp's "giving up" error.
+func TestLastResponseAfterRetries(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, ClientOptions{RetryMax: 2}, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"code":503,"message":"maintenance"}`))
+	})
+	_, err := c.ListClusterRebalanceNodeClasses(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Message != "maintenance" {
+		t.Fatalf("got %v, want the 503 as an APIError", err)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+const (
+	enableAll      = "all"
+	enableNone     = "none"
+	enablePreserve = "preserve"
+)
+
+// enablePolicy decides the Enable flag of the NodePools a run uploads.
+type enablePolicy struct {
+	// Mode is enableAll, enableNone, enablePreserve or "" to keep the flag as
+	// listed: on for cluster NodePools, as written for bundles.
+	Mode string
+	// Overrides maps NodePool names to their flag and wins over Mode.
+	Overrides map[string]bool
+}
+
+// parseEnablePolicy reads --enable and the name=true|false pairs of
+// --enable-override.
+func parseEnablePolicy(mode string, overrides []string) (enablePolicy, error) {
+	switch mode {
+	case "", enableAll, enableNone, enablePreserve:
+	default:
+		return enablePolicy{}, fmt.Errorf("--enable must be %s, %s or %s, not %q", enableAll, enableNone, enablePreserve, mode)
+	}
+	p := enablePolicy{Mode: mode, Overrides: make(map[string]bool, len(overrides))}
+	for _, o := range overrides {
+		name, value, ok := strings.Cut(o, "=")
+		enable, err := strconv.ParseBool(value)
+		if !ok || name == "" || err != nil {
+			return enablePolicy{}, fmt.Errorf("--enable-override %q is not name=true|false", o)
+		}
+		p.Overrides[name] = enable
+	}
+	return p, nil
+}
+
+// apply sets the Enable flag of each NodePool. preserve keeps the flag the
+// server has for the pool; a pool new to the server starts disabled.
+// Overrides naming no NodePool are an error, they are most likely typos.
+func (p enablePolicy) apply(nodepools, serverNodePools []RebalanceNodePool) ([]RebalanceNodePool, error) {
+	onServer := make(map[string]bool, len(serverNodePools))
+	for _, np := range serverNodePools {
+		onServer[np.GetName()] = np.Enabled()
+	}
+	names := make(map[string]struct{}, len(nodepools))
+	out := make([]RebalanceNodePool, 0, len(nodepools))
+	for _, np := range nodepools {
+		name := np.GetName()
+		names[name] = struct{}{}
+		enable := np.Enabled()
+		switch p.Mode {
+		case enableAll:
+			enable = true
+		case enableNone:
+			enable = false
+		case enablePreserve:
+			enable = onServer[name]
+		}
+		if e, ok := p.Overrides[name]; ok {
+			enable = e
+		}
+		out = append(out, np.WithEnable(enable))
+	}
+	for name := range p.Overrides {
+		if _, ok := names[name]; !ok {
+			return nil, fmt.Errorf("--enable-override names NodePool %q, which is not uploaded", name)
+		}
+	}
+	return out, nil
+}
+
+// mustApplyEnable is apply that stops before anything is changed.
+func mustApplyEnable(policy enablePolicy, nodepools, serverNodePools []RebalanceNodePool) []RebalanceNodePool {
+	nodepools, err := policy.apply(nodepools, serverNodePools)
+	if err != nil {
+		fatalf(exitError, "%v, nothing changed", err)
+	}
+	return nodepools
+}
+
+// runSetEnable turns rebalancing of the selected server-side NodePools on or
+// off. There is no endpoint for the flag alone, so each pool is re-applied
+// from the server's own copy with only Enable changed.
+func runSetEnable(ctx context.Context, c *Client, sel selection, enable bool, ch changeOptions) {
+	what := "disable"
+	if enable {
+		what = "enable"
+	}
+	serverNodePools, serverNodeClasses, run := beginRun(ctx, c, what, ch)
+	var flip []RebalanceNodePool
+	for _, np := range serverNodePools.Items() {
+		if !sel.matches(sel.NodePools, np.GetName()) {
+			continue
+		}
+		if np.Enabled() == enable {
+			klog.Infof("nodepool %s is already %sd", np.GetName(), what)
+			continue
+		}
+		flip = append(flip, np.WithEnable(enable))
+	}
+	// On resume, pools flipped by the first run still show their old flag in
+	// its backup, the journal skips them.
+	printNodePools(fmt.Sprintf("Server-side NodePools to %s", strings.ToUpper(what)), flip)
+	if len(flip) == 0 {
+		klog.Infof("nothing to %s", what)
+		return
+	}
+
+	prompt := fmt.Sprintf("Type '%s' to %s rebalancing of the NodePools above, or anything else to abort: ", what, what)
+	if !ch.Confirm.confirm(prompt, what, false, true) {
+		fatalf(exitAborted, "aborted by user; nothing changed")
+	}
+	run.mustStart(c, ch.BackupDir, serverNodePools, serverNodeClasses)
+
+	var steps []step
+	for _, np := range flip {
+		steps = append(steps, applyNodePoolStep(c, np, nil))
+	}
+	applyChanges(ctx, c, run, steps, ch)
+}
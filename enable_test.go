@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnablePolicyApply(t *testing.T) {
+	disabled := func(np RebalanceNodePool) RebalanceNodePool { return np.WithEnable(false) }
+	// a is on in the cluster and off on the server, b is off in the cluster
+	// and on on the server, new is not on the server yet
+	nodepools := []RebalanceNodePool{testNodePool("a", "x"), disabled(testNodePool("b", "x")), testNodePool("new", "x")}
+	serverNodePools := []RebalanceNodePool{disabled(testNodePool("a", "x")), testNodePool("b", "x")}
+
+	tests := []struct {
+		name      string
+		policy    enablePolicy
+		want      map[string]bool
+		wantError string
+	}{
+		{name: "as listed", want: map[string]bool{"a": true, "b": false, "new": true}},
+		{name: "all", policy: enablePolicy{Mode: enableAll}, want: map[string]bool{"a": true, "b": true, "new": true}},
+		{name: "none", policy: enablePolicy{Mode: enableNone}, want: map[string]bool{"a": false, "b": false, "new": false}},
+		{name: "preserve", policy: enablePolicy{Mode: enablePreserve}, want: map[string]bool{"a": false, "b": true, "new": false}},
+		{
+			name:   "override wins over the mode",
+			policy: enablePolicy{Mode: enableNone, Overrides: map[string]bool{"b": true}},
+			want:   map[string]bool{"a": false, "b": true, "new": false},
+		},
+		{
+			name:   "override wins over preserve",
+			policy: enablePolicy{Mode: enablePreserve, Overrides: map[string]bool{"a": true, "new": true}},
+			want:   map[string]bool{"a": true, "b": true, "new": true},
+		},
+		{
+			name:      "override naming no uploaded pool",
+			policy:    enablePolicy{Mode: enableAll, Overrides: map[string]bool{"typo": false}},
+			wantError: `NodePool "typo", which is not uploaded`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.policy.apply(nodepools, serverNodePools)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]bool, len(out))
+			for _, np := range out {
+				got[np.GetName()] = np.Enabled()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnablePolicy(t *testing.T) {
+	p, err := parseEnablePolicy(enablePreserve, []string{"a=true", "b=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := enablePolicy{Mode: enablePreserve, Overrides: map[string]bool{"a": true, "b": false}}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+	for _, tt := range []struct {
+		mode      string
+		overrides []string
+	}{
+		{mode: "some"},
+		{overrides: []string{"a"}},
+		{overrides: []string{"=true"}},
+		{overrides: []string{"a=yes"}},
+	} {
+		if _, err := parseEnablePolicy(tt.mode, tt.overrides); err == nil {
+			t.Errorf("--enable %q --enable-override %v: got no error", tt.mode, tt.overrides)
+		}
+	}
+}
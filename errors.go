@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// APIError is a non-200 response from the CloudPilot AI API.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Code and Message come from the response envelope, they are empty when
+	// the body was not JSON.
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s: %s (HTTP %d, code %d): %s", e.Method, e.URL, e.Reason(), e.StatusCode, e.Code, msg)
+}
+
+// Reason classifies the status code.
+func (e *APIError) Reason() string {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return "authentication failed"
+	case e.StatusCode == http.StatusNotFound:
+		return "not found"
+	case e.StatusCode == http.StatusConflict:
+		return "conflict"
+	case e.StatusCode == http.StatusUnprocessableEntity:
+		return "rejected as invalid"
+	case e.StatusCode >= 500:
+		return "server error"
+	}
+	return "request failed"
+}
+
+func apiStatus(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+func isNotFound(err error) bool {
+	return apiStatus(err) == http.StatusNotFound
+}
+
+func isAuthError(err error) bool {
+	status := apiStatus(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// apiErrorHint says what to do about an API error, or "" if there is nothing
+// specific to say.
+func apiErrorHint(err error) string {
+	switch apiStatus(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "check that CLOUDPILOT_API_KEY is set to a valid key with access to this cluster"
+	case http.StatusConflict:
+		return "the object was changed on the server meanwhile, check it with diff and run again"
+	case http.StatusUnprocessableEntity:
+		return "the server rejected the spec, fix the object in the cluster or the bundle"
+	}
+	return ""
+}
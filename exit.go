@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Exit codes, so a pipeline can tell a refusal apart from a half-done run.
+const (
+	exitError            = 1 // bad flags, unreachable cluster or server, backup failure
+	exitAborted          = 2 // not confirmed at the prompt, or interrupted
+	exitValidationFailed = 3 // dangling references, orphans without a policy, CRD rule violations
+	exitDeleteFailed     = 4
+	exitUploadFailed     = 5
+	exitVerifyFailed     = 6 // the run succeeded but the server doesn't store what it should
+	exitNotConfirmed     = 8 // no terminal to prompt and no flag approved the changes
+)
+
+// fatalf prints the error to stderr and exits with code.
+func fatalf(code int, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	os.Exit(code)
+}
+
+// fatalAPIf is fatalf for a failed API call, with a hint on what to do about
+// the error when there is one.
+func fatalAPIf(code int, err error, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if hint := apiErrorHint(err); hint != "" {
+		fatalf(code, "%s: %v\nhint: %s", msg, err, hint)
+	}
+	fatalf(code, "%s: %v", msg, err)
+}
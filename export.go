@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// bundle is the reviewable file export writes and import uploads. It holds
+// the envelopes exactly as they are sent to the server: no status, no object
+// metadata and no timestamp, so re-exporting an unchanged cluster gives the
+// same file.
+type bundle struct {
+	ClusterID   string               `json:"clusterID,omitempty"`
+	NodePools   []RebalanceNodePool  `json:"nodePools"`
+	NodeClasses []RebalanceNodeClass `json:"nodeClasses"`
+}
+
+// writeBundle writes b as JSON if path ends in .json, as YAML otherwise.
+func writeBundle(path string, b bundle) error {
+	sort.Slice(b.NodePools, func(i, j int) bool { return b.NodePools[i].GetName() < b.NodePools[j].GetName() })
+	sort.Slice(b.NodeClasses, func(i, j int) bool { return b.NodeClasses[i].GetName() < b.NodeClasses[j].GetName() })
+
+	data, err := marshalFile(path, b)
+	if err != nil {
+		return fmt.Errorf("marshal bundle: %w", err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// readBundle reads a YAML or JSON bundle and checks that every envelope has
+// exactly one variant with a name and spec.
+func readBundle(path string) (bundle, error) {
+	var b bundle
+	if err := readFileStrict(path, &b); err != nil {
+		return b, err
+	}
+	for i, np := range b.NodePools {
+		if (np.ECSNodePool == nil) == (np.EC2NodePool == nil) {
+			return b, fmt.Errorf("nodePools[%d]: exactly one of ecsNodePool and ec2NodePool must be set", i)
+		}
+		if np.GetName() == "" || np.Spec() == nil {
+			return b, fmt.Errorf("nodePools[%d]: name and nodePoolSpec are required", i)
+		}
+	}
+	for i, nc := range b.NodeClasses {
+		if (nc.ECSNodeClass == nil) == (nc.EC2NodeClass == nil) {
+			return b, fmt.Errorf("nodeClasses[%d]: exactly one of ecsNodeClass and ec2NodeClass must be set", i)
+		}
+		if nc.GetName() == "" || nc.Spec() == nil {
+			return b, fmt.Errorf("nodeClasses[%d]: name and nodeClassSpec are required", i)
+		}
+	}
+	return b, nil
+}
+
+// runExport writes the selected cluster objects to a bundle, so they can be
+// reviewed and imported later without access to the cluster.
+func runExport(ctx context.Context, kubeClient client.Client, provider string, sel selection, acceptConversion bool, clusterID, out string) {
+	nodepools, nodeclasses, issues := listCluster(ctx, kubeClient, provider, sel)
+	mustAcceptConversion(issues, acceptConversion)
+	b := bundle{ClusterID: clusterID, NodePools: nodepools, NodeClasses: nodeclasses}
+	if err := writeBundle(out, b); err != nil {
+		fatalf(exitError, "write bundle: %v", err)
+	}
+	klog.Infof("exported %d NodePool(s) and %d NodeClass(es) to %s", len(nodepools), len(nodeclasses), out)
+}
+
+// runImport uploads the objects of a bundle. It only talks to the server.
+// Same-named server-side objects are overwritten, everything else on the
+// server is left untouched.
+func runImport(ctx context.Context, c *Client, from string, orphans orphanPolicy, enable enablePolicy, ch changeOptions) {
+	b, err := readBundle(from)
+	if err != nil {
+		fatalf(exitError, "failed to read bundle: %v", err)
+	}
+	if b.ClusterID != "" && b.ClusterID != c.ClusterID {
+		klog.Warningf("bundle %s was exported for cluster %q, importing into %q", from, b.ClusterID, c.ClusterID)
+	}
+	serverNodePools, serverNodeClasses, run := beginRun(ctx, c, "import", ch)
+	nodepools := mustApplyEnable(enable, b.NodePools, serverNodePools.Items())
+	kept := keptOnServer(serverNodePools.Items(), serverNodeClasses.Items(), nil, nil, nodepools)
+	nodeclasses := mustCheckReferences(nodepools, b.NodeClasses, kept, orphans)
+	mustValidate(nodeclasses)
+
+	printNodePools("NodePools to UPLOAD", nodepools)
+	printNodeClasses("NodeClasses to UPLOAD", nodeclasses)
+	if !ch.Confirm.confirm("Type 'import' to upload the objects above to CloudPilot AI, or anything else to abort: ", "import", false, true) {
+		fatalf(exitAborted, "aborted by user; nothing uploaded")
+	}
+	run.mustStart(c, ch.BackupDir, serverNodePools, serverNodeClasses)
+
+	applyChanges(ctx, c, run, uploadSteps(c, nodeclasses, nodepools), ch)
+}
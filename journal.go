@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journalEntry is one line of a journal. The first line of a journal is a
+// "start" entry, then every finished step is a "step" entry, and a rollback
+// ends the journal with a "rollback" entry.
+type journalEntry struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// start
+	ClusterID string `json:"clusterID,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Backup    string `json:"backup,omitempty"`
+
+	// step
+	Action   string `json:"action,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Name     string `json:"name,omitempty"`
+	SpecHash string `json:"specHash,omitempty"`
+	// Status is "done" or "failed" for a step, "ok" or "incomplete" for a rollback.
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// journal records the steps of a run as they finish, so an interrupted or
+// failed run can be resumed without repeating what was already done.
+type journal struct {
+	Path   string
+	Backup string
+
+	mu sync.Mutex
+	f  *os.File
+	// done maps the steps completed by earlier runs to their spec hash.
+	done map[string]string
+}
+
+func specHash(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// createJournal starts the journal of a new run next to its backup.
+func createJournal(dir, clusterID, command, backupPath string, createdAt time.Time) (*journal, error) {
+	backup, err := filepath.Abs(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("ack_migrate-journal-%s-%s.jsonl", clusterID, createdAt.Format("20060102T150405Z")))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create journal: %w", err)
+	}
+	j := &journal{Path: path, Backup: backup, f: f, done: map[string]string{}}
+	if err := j.append(journalEntry{Type: "start", ClusterID: clusterID, Command: command, Backup: backup}); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// resumeJournal reopens the journal of an earlier run of the same command on
+// the same cluster. A run that was rolled back can't be resumed, its steps
+// were undone.
+func resumeJournal(path, clusterID, command string) (*journal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{Path: path, done: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		switch e.Type {
+		case "start":
+			if e.ClusterID != clusterID || e.Command != command {
+				_ = f.Close()
+				return nil, fmt.Errorf("journal %s is of %q on cluster %q, not %q on %q", path, e.Command, e.ClusterID, command, clusterID)
+			}
+			j.Backup = e.Backup
+		case "step":
+			if e.Status == "done" {
+				j.done[stepKey(e.Action, e.Kind, e.Name)] = e.SpecHash
+			}
+		case "rollback":
+			_ = f.Close()
+			return nil, fmt.Errorf("the run of journal %s was rolled back (%s), start a new run instead", path, e.Status)
+		}
+	}
+	_ = f.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if j.Backup == "" {
+		return nil, fmt.Errorf("journal %s has no start entry", path)
+	}
+
+	if j.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// completed reports whether an earlier run already did s with the same spec.
+func (j *journal) completed(s step) bool {
+	if j == nil {
+		return false
+	}
+	hash, ok := j.done[s.String()]
+	return ok && hash == s.SpecHash
+}
+
+func (j *journal) recordStep(s step, r stepResult) error {
+	if j == nil {
+		return nil
+	}
+	e := journalEntry{Type: "step", Action: s.Action, Kind: s.Kind, Name: s.Name, SpecHash: s.SpecHash, Status: "done"}
+	if r.Status == stepFailed {
+		e.Status = "failed"
+		e.Error = r.Err.Error()
+	}
+	return j.append(e)
+}
+
+func (j *journal) recordRollback(ok bool) error {
+	if j == nil {
+		return nil
+	}
+	status := "ok"
+	if !ok {
+		status = "incomplete"
+	}
+	return j.append(journalEntry{Type: "rollback", Status: status})
+}
+
+// append writes e as one line and syncs it, so the journal survives a crash.
+func (j *journal) append(e journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.Time = time.Now().UTC()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return j.f.Sync()
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
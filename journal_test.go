@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testJournal(t *testing.T) *journal {
+	t.Helper()
+	j, err := createJournal(t.TempDir(), "c1", "migrate", "backup.json", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("createJournal: %v", err)
+	}
+	return j
+}
+
+func TestResumeJournal(t *testing.T) {
+	j := testJournal(t)
+	a := step{Action: "apply", Kind: "NodePool", Name: "a", SpecHash: "sha256:a1"}
+	b := step{Action: "apply", Kind: "NodePool", Name: "b", SpecHash: "sha256:b1"}
+	del := step{Action: "delete", Kind: "NodeClass", Name: "x"}
+	for _, rec := range []struct {
+		s step
+		r stepResult
+	}{
+		{a, stepResult{Status: stepDone}},
+		{b, stepResult{Status: stepFailed, Err: errors.New("boom")}},
+		{del, stepResult{Status: stepDone}},
+	} {
+		if err := j.recordStep(rec.s, rec.r); err != nil {
+			t.Fatalf("recordStep: %v", err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	resumed, err := resumeJournal(j.Path, "c1", "migrate")
+	if err != nil {
+		t.Fatalf("resumeJournal: %v", err)
+	}
+	defer resumed.Close()
+	if resumed.Backup != j.Backup {
+		t.Errorf("Backup = %q, want %q", resumed.Backup, j.Backup)
+	}
+
+	changed := a
+	changed.SpecHash = "sha256:a2"
+	for _, tt := range []struct {
+		s    step
+		want bool
+	}{
+		{a, true},
+		{changed, false}, // the object changed since, apply it again
+		{b, false},       // failed steps are repeated
+		{del, true},
+		{step{Action: "delete", Kind: "NodePool", Name: "a"}, false},
+	} {
+		if got := resumed.completed(tt.s); got != tt.want {
+			t.Errorf("completed(%s, %s) = %t, want %t", tt.s, tt.s.SpecHash, got, tt.want)
+		}
+	}
+
+	// a resumed run skips exactly the completed steps
+	ran := map[string]bool{}
+	steps := []step{a, changed, b}
+	for i := range steps {
+		s := &steps[i]
+		s.do = func(context.Context) error {
+			ran[s.String()+" "+s.SpecHash] = true
+			return nil
+		}
+	}
+	results, err := runSteps(context.Background(), steps[:1], 1, resumed)
+	if err != nil || !results[0].Resumed || len(ran) != 0 {
+		t.Errorf("completed step: results %+v, err %v, ran %v", results, err, ran)
+	}
+	results, err = runSteps(context.Background(), steps[1:], 1, resumed)
+	if err != nil || results[0].Resumed || results[1].Resumed || len(ran) != 2 {
+		t.Errorf("steps to repeat: results %+v, err %v, ran %v", results, err, ran)
+	}
+}
+
+func TestResumeJournalRefuses(t *testing.T) {
+	rolledBack := testJournal(t)
+	if err := rolledBack.recordStep(step{Action: "delete", Kind: "NodePool", Name: "a"}, stepResult{Status: stepDone}); err != nil {
+		t.Fatalf("recordStep: %v", err)
+	}
+	if err := rolledBack.recordRollback(true); err != nil {
+		t.Fatalf("recordRollback: %v", err)
+	}
+	rolledBack.Close()
+
+	other := testJournal(t)
+	other.Close()
+
+	for _, tt := range []struct {
+		name      string
+		path      string
+		clusterID string
+		command   string
+		want      string
+	}{
+		{"rolled back", rolledBack.Path, "c1", "migrate", "was rolled back"},
+		{"other cluster", other.Path, "c2", "migrate", `not "migrate" on "c2"`},
+		{"other command", other.Path, "c1", "reconcile", `not "reconcile" on "c1"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := resumeJournal(tt.path, tt.clusterID, tt.command)
+			if err == nil {
+				j.Close()
+				t.Fatalf("resumeJournal succeeded, want an error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,85 +2,578 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
-	alibabacloudproviderv1alpha1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis/v1alpha1"
-	alibabacloudcorev1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func init() {
-	_ = alibabacloudproviderv1alpha1.SchemeBuilder.AddToScheme(scheme.Scheme)
-	_ = alibabacloudcorev1.SchemeBuilder.AddToScheme(scheme.Scheme)
+var commands = []command{
+	{
+		name:    "server list",
+		summary: "print the rebalance config stored on the server",
+		help:    "Prints the NodePools and NodeClasses CloudPilot AI currently holds for the cluster.",
+		flags:   serverFlags,
+		run: func(ctx context.Context, o *options) {
+			runServerList(ctx, o.serverClient())
+		},
+	},
+	{
+		name:    "cluster list",
+		summary: "print the Karpenter NodePools and NodeClasses in the cluster",
+		help:    "Prints the NodePools and NodeClasses in the cluster, in the shape they would be uploaded.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			clusterFlags(fs, o)
+			selectionFlags(fs, o, true)
+		},
+		run: func(ctx context.Context, o *options) {
+			kubeClient, provider, _ := o.kubeClient()
+			runClusterList(ctx, kubeClient, provider, o.selection())
+		},
+	},
+	{
+		name:    "export",
+		summary: "write the cluster objects to a reviewable bundle",
+		help:    "Writes the NodePools and NodeClasses in the cluster to a YAML bundle, or JSON if --out ends in .json, in the shape they are uploaded. import applies it later without access to the cluster.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			fs.StringVar(&o.clusterID, "clusterid", "", "CloudPilot AI cluster id recorded in the bundle")
+			fs.StringVar(&o.out, "out", "", "bundle file to write, e.g. bundle.yaml (required)")
+			clusterFlags(fs, o)
+			selectionFlags(fs, o, true)
+			conversionFlags(fs, o)
+		},
+		run: func(ctx context.Context, o *options) {
+			if o.out == "" {
+				fatalf(exitError, "--out is required for export")
+			}
+			kubeClient, provider, _ := o.kubeClient()
+			runExport(ctx, kubeClient, provider, o.selection(), o.acceptConv, o.clusterID, o.out)
+		},
+	},
+	{
+		name:    "import",
+		summary: "upload an exported bundle, without access to the cluster",
+		help:    "Uploads the NodePools and NodeClasses of a bundle written by export. Only needs CLOUDPILOT_API_KEY, not KUBECONFIG. Server-side objects not in the bundle are left untouched.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			serverFlags(fs, o)
+			fs.StringVar(&o.from, "from", "", "bundle written by export, YAML or JSON (required)")
+			backupFlags(fs, o)
+			orphanFlags(fs, o)
+			enableFlags(fs, o)
+			changeFlags(fs, o)
+		},
+		run: func(ctx context.Context, o *options) {
+			if o.from == "" {
+				fatalf(exitError, "--from is required for import")
+			}
+			runImport(ctx, o.serverClient(), o.from, o.orphanPolicy(), o.enablePolicy(), o.changeOptions())
+		},
+	},
+	{
+		name:    "delete",
+		summary: "back up and delete the server-side config",
+		help:    "Backs up the server-side config, then deletes the selected server-side NodePools and NodeClasses, all of them without filters.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			serverFlags(fs, o)
+			backupFlags(fs, o)
+			selectionFlags(fs, o, false)
+			changeFlags(fs, o)
+		},
+		run: func(ctx context.Context, o *options) {
+			runDelete(ctx, o.serverClient(), o.selection(), o.changeOptions())
+		},
+	},
+	{
+		name:    "migrate",
+		summary: "back up and delete the server-side config, then upload the cluster objects",
+		help:    "Backs up and deletes the selected server-side config, then uploads the cluster objects. A failed delete or upload is rolled back from the backup, or with --no-rollback left as it is to be finished with --resume.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			serverFlags(fs, o)
+			clusterFlags(fs, o)
+			backupFlags(fs, o)
+			selectionFlags(fs, o, true)
+			conversionFlags(fs, o)
+			orphanFlags(fs, o)
+			enableFlags(fs, o)
+			changeFlags(fs, o)
+		},
+		run: func(ctx context.Context, o *options) {
+			c := o.serverClient()
+			kubeClient, provider, target := o.kubeClient()
+			runMigrate(ctx, c, kubeClient, provider, target, o.selection(), o.orphanPolicy(), o.enablePolicy(), o.acceptConv, o.changeOptions())
+		},
+	},
+	{
+		name:    "reconcile",
+		summary: "apply only new or changed objects, delete server-only objects with --prune",
+		help:    "Uploads the cluster objects that are new or changed compared to the server. Unchanged objects are not touched; server-only objects are deleted with --prune. NodePools keep the Enable flag they have on the server, new ones start disabled, unless --enable is given.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			serverFlags(fs, o)
+			clusterFlags(fs, o)
+			backupFlags(fs, o)
+			selectionFlags(fs, o, true)
+			conversionFlags(fs, o)
+			orphanFlags(fs, o)
+			enableFlags(fs, o)
+			changeFlags(fs, o)
+			fs.BoolVar(&o.prune, "prune", false, "delete server-side objects that no longer exist in the cluster")
+		},
+		run: func(ctx context.Context, o *options) {
+			c := o.serverClient()
+			kubeClient, provider, target := o.kubeClient()
+			runReconcile(ctx, c, kubeClient, provider, target, o.selection(), o.prune, o.orphanPolicy(), o.enablePolicy(), o.acceptConv, o.changeOptions())
+		},
+	},
+	{
+		name:    "diff",
+		aliases: []string{"plan"},
+		summary: "show what a migration would change, without touching anything",
+		help:    "Compares the server-side config with the cluster objects field by field. Read-only on both sides.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			serverFlags(fs, o)
+			clusterFlags(fs, o)
+			selectionFlags(fs, o, true)
+			enableFlags(fs, o)
+		},
+		run: func(ctx context.Context, o *options) {
+			c := o.serverClient()
+			kubeClient, provider, target := o.kubeClient()
+			runPlan(ctx, c, kubeClient, provider, target, o.selection(), o.enablePolicy())
+		},
+	},
+	{
+		name:    "restore",
+		summary: "re-apply a server-side backup",
+		help:    "Re-applies a backup written before migrate, reconcile, import or delete changed the server.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			serverFlags(fs, o)
+			fs.StringVar(&o.from, "from", "", "backup file to re-apply (required)")
+			changeFlags(fs, o)
+		},
+		run: func(ctx context.Context, o *options) {
+			if o.from == "" {
+				fatalf(exitError, "--from is required for restore")
+			}
+			runRestore(ctx, o.serverClient(), o.from, o.changeOptions())
+		},
+	},
+	{
+		name:    "workloads scan",
+		summary: "propose the workload rebalance config from the cluster Deployments and StatefulSets",
+		help:    "Derives the rebalance config of each Deployment and StatefulSet the way the agent does: spot-friendly and min-nonspot from the workload labels, rebalance-able from the do-not-disrupt annotation of its pods. With --out the proposal is written to a file to review.",
+		flags: func(fs *flag.FlagSet, o *options) {
+			fs.StringVar(&o.clusterID, "clusterid", "", "CloudPilot AI cluster id recorded in the file")
+			fs.StringVar(&o.out, "out", "", "file to write the proposal to, e.g. workloads.yaml")
+			kubeFlags(fs, o)
+			fs.Var(&o.namespaces, "namespace", "only workloads in namespaces matching these globs, repeatable or comma-separated")
+			fs.Var(&o.exclude, "exclude", "leave out namespaces matching these globs, repeatable or comma-separated")
+			fs.StringVar(&o.selector, "selector", "", "only workloads matching this label selector, e.g. team=a,env!=dev")
+		},
+		run: func(ctx context.Context, o *options) {
+			f := o.workloadFilter()
+			runWorkloadsScan(ctx, o.workloadsClient(), f, o.clusterID, o.out)
+		},
+	},
+	{
+		name:    "enable",
+		summary: "turn on rebalancing of server-side NodePools",
+		help:    "Sets Enable on the selected server-side NodePools, which can be uploaded disabled with --enable=none and turned on one by one. Each pool is re-applied from the server's own copy, its spec is not taken from the cluster.",
+		flags:   setEnableFlags,
+		run: func(ctx context.Context, o *options) {
+			runSetEnable(ctx, o.serverClient(), o.enableSelection(), true, o.changeOptions())
+		},
+	},
+	{
+		name:    "disable",
+		summary: "turn off rebalancing of server-side NodePools",
+		help:    "Clears Enable on the selected server-side NodePools. Each pool is re-applied from the server's own copy, its spec is not taken from the cluster.",
+		flags:   setEnableFlags,
+		run: func(ctx context.Context, o *options) {
+			runSetEnable(ctx, o.serverClient(), o.enableSelection(), false, o.changeOptions())
+		},
+	},
 }
 
 func main() {
-	var clusterID string
-	flag.StringVar(&clusterID, "clusterid", "", "CloudPilot AI cluster id (required)")
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		if len(args) > 1 {
+			if cmd, _ := findCommand(args[1:]); cmd != nil {
+				runCommand(cmd, []string{"-h"})
+			}
+		}
+		usage()
+		if len(args) == 0 {
+			os.Exit(exitError)
+		}
+		return
+	}
 
-	if clusterID == "" {
-		panic("--clusterid is required")
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		usage()
+		fatalf(exitError, "unknown command %q", args[0])
 	}
-	kubeconfig := os.Getenv("KUBECONFIG")
-	if kubeconfig == "" {
-		panic(fmt.Errorf("KUBECONFIG env is empty"))
+	runCommand(cmd, rest)
+}
+
+// runServerList prints the server-side config of the cluster.
+func runServerList(ctx context.Context, c *Client) {
+	serverNodePools, serverNodeClasses := listServer(ctx, c)
+	printNodePools("Server-side NodePools", serverNodePools.Items())
+	printNodeClasses("Server-side NodeClasses", serverNodeClasses.Items())
+}
+
+// runClusterList prints the selected cluster objects as they would be uploaded.
+func runClusterList(ctx context.Context, kubeClient client.Client, provider string, sel selection) {
+	nodepools, nodeclasses, issues := listCluster(ctx, kubeClient, provider, sel)
+	printConversionIssues(issues)
+	printNodePools("Cluster NodePools", nodepools)
+	printNodeClasses("Cluster NodeClasses", nodeclasses)
+}
+
+// runDelete backs up the server side and deletes the selected server-side
+// objects without uploading anything.
+func runDelete(ctx context.Context, c *Client, sel selection, ch changeOptions) {
+	serverNodePools, serverNodeClasses, run := beginRun(ctx, c, "delete", ch)
+	scopedPools, scopedClasses := sel.filterServer(serverNodePools.Items(), serverNodeClasses.Items(), nil, nil)
+	mustCheckReferences(nil, nil, keptOnServer(serverNodePools.Items(), serverNodeClasses.Items(), scopedPools, scopedClasses, nil), orphanPolicy{})
+	printNodePools("Server-side NodePools to DELETE", scopedPools)
+	printNodeClasses("Server-side NodeClasses to DELETE", scopedClasses)
+	if len(scopedPools)+len(scopedClasses) == 0 {
+		klog.Infof("nothing to delete")
+		return
 	}
-	ak := os.Getenv("CLOUDPILOT_API_KEY")
-	if ak == "" {
-		panic(fmt.Errorf("CLOUDPILOT_API_KEY env is empty"))
+
+	if !ch.Confirm.confirm("Type 'delete' to DELETE the server-side objects above, or anything else to abort: ", "delete", true, false) {
+		fatalf(exitAborted, "aborted by user; nothing deleted")
 	}
+	run.mustStart(c, ch.BackupDir, serverNodePools, serverNodeClasses)
+
+	applyChanges(ctx, c, run, deleteSteps(c, scopedPools, scopedClasses), ch)
+}
 
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+// runPlan prints what a migration would change. It is read-only on both sides.
+func runPlan(ctx context.Context, c *Client, kubeClient client.Client, provider string, target kubeTarget, sel selection, enable enablePolicy) {
+	serverNodePools, serverNodeClasses := listServer(ctx, c)
+	nodepools, nodeclasses, issues := listCluster(ctx, kubeClient, provider, sel)
+	scopedPools, scopedClasses := sel.filterServer(serverNodePools.Items(), serverNodeClasses.Items(), nodepools, nodeclasses)
+	nodepools = mustApplyEnable(enable, nodepools, serverNodePools.Items())
+
+	printTarget(c.ClusterID, target)
+	printConversionIssues(issues)
+	printPlan(buildPlan(scopedPools, scopedClasses, nodepools, nodeclasses))
+	kept := keptOnServer(serverNodePools.Items(), serverNodeClasses.Items(), scopedPools, scopedClasses, nodepools)
+	printRefReport(checkReferences(nodepools, nodeclasses, kept))
+	if errs := validateNodeClasses(nodeclasses); len(errs) > 0 {
+		printValidationErrors(errs)
+	}
+}
+
+func runMigrate(ctx context.Context, c *Client, kubeClient client.Client, provider string, target kubeTarget, sel selection, orphans orphanPolicy, enable enablePolicy, acceptConversion bool, ch changeOptions) {
+	// List both sides before touching anything, so the preview shows exactly
+	// what will be deleted on the server and what will be uploaded.
+	serverNodePools, serverNodeClasses, run := beginRun(ctx, c, "migrate", ch)
+	nodepools, nodeclasses, issues := listCluster(ctx, kubeClient, provider, sel)
+	mustAcceptConversion(issues, acceptConversion)
+	scopedPools, scopedClasses := sel.filterServer(serverNodePools.Items(), serverNodeClasses.Items(), nodepools, nodeclasses)
+	nodepools = mustApplyEnable(enable, nodepools, serverNodePools.Items())
+	// Selected server objects are deleted, so only the unselected ones stay
+	kept := keptOnServer(serverNodePools.Items(), serverNodeClasses.Items(), scopedPools, scopedClasses, nodepools)
+	nodeclasses = mustCheckReferences(nodepools, nodeclasses, kept, orphans)
+	mustValidate(nodeclasses)
+
+	// Preview tables
+	printTarget(c.ClusterID, target)
+	printPreviewTables(scopedPools, scopedClasses, nodepools, nodeclasses)
+
+	// Require explicit "migrate", nothing is deleted before this point
+	deletes := len(scopedPools)+len(scopedClasses) > 0
+	uploads := len(nodepools)+len(nodeclasses) > 0
+	if !ch.Confirm.confirm("Type 'migrate' to DELETE the server-side objects above and upload the cluster objects, or anything else to abort: ", "migrate", deletes, uploads) {
+		fatalf(exitAborted, "aborted by user; nothing deleted, nothing uploaded")
+	}
+
+	// Back up the server side first, never delete without a copy
+	run.mustStart(c, ch.BackupDir, serverNodePools, serverNodeClasses)
+
+	// Delete from server, then upload to CloudPilot
+	steps := append(deleteSteps(c, scopedPools, scopedClasses), uploadSteps(c, nodeclasses, nodepools)...)
+	applyChanges(ctx, c, run, steps, ch)
+}
+
+// runReconcile upserts what changed instead of deleting everything first, so
+// unchanged pools stay live on the server for the whole run.
+func runReconcile(ctx context.Context, c *Client, kubeClient client.Client, provider string, target kubeTarget, sel selection, prune bool, orphans orphanPolicy, enable enablePolicy, acceptConversion bool, ch changeOptions) {
+	serverNodePools, serverNodeClasses, run := beginRun(ctx, c, "reconcile", ch)
+	nodepools, clusterClasses, issues := listCluster(ctx, kubeClient, provider, sel)
+	mustAcceptConversion(issues, acceptConversion)
+	scopedPools, scopedClasses := sel.filterServer(serverNodePools.Items(), serverNodeClasses.Items(), nodepools, clusterClasses)
+	// pools keep the server's flag unless --enable was given, so a pool
+	// disabled on the server is not turned back on
+	if enable.Mode == "" {
+		enable.Mode = enablePreserve
+	}
+	nodepools = mustApplyEnable(enable, nodepools, serverNodePools.Items())
+	kept := keptOnServer(serverNodePools.Items(), serverNodeClasses.Items(), nil, nil, nodepools)
+	if prune {
+		kept = keptOnServer(serverNodePools.Items(), serverNodeClasses.Items(), scopedPools, scopedClasses, nodepools)
+	}
+	nodeclasses := mustCheckReferences(nodepools, clusterClasses, kept, orphans)
+	scopedClasses = reconcileScope(scopedClasses, clusterClasses, nodeclasses)
+	mustValidate(nodeclasses)
+
+	p := buildPlan(scopedPools, scopedClasses, nodepools, nodeclasses)
+	printTarget(c.ClusterID, target)
+	printPlan(p)
+
+	counts := p.counts()
+	if !prune && counts[planDelete] > 0 {
+		fmt.Printf("%d server-only object(s) will be kept, pass --prune to delete them\n", counts[planDelete])
+	}
+	if counts[planCreate]+counts[planUpdate] == 0 && (!prune || counts[planDelete] == 0) {
+		klog.Infof("server is already in sync with the cluster; nothing to do")
+		return
+	}
+
+	deletes := prune && counts[planDelete] > 0
+	uploads := counts[planCreate]+counts[planUpdate] > 0
+	if !ch.Confirm.confirm("Type 'reconcile' to apply the changes above to CloudPilot AI, or anything else to abort: ", "reconcile", deletes, uploads) {
+		fatalf(exitAborted, "aborted by user; nothing changed")
+	}
+
+	run.mustStart(c, ch.BackupDir, serverNodePools, serverNodeClasses)
+
+	applyChanges(ctx, c, run, reconcileSteps(c, p, scopedPools, nodeclasses, nodepools, prune), ch)
+}
+
+// runState is the backup and journal of a run that changes the server.
+type runState struct {
+	what       string
+	snapshot   serverSnapshot
+	backupPath string
+	journal    *journal
+}
+
+// beginRun returns the server side a run starts from. A resumed run starts
+// from the backup of its first run instead of the live server, so objects the
+// first run uploaded are not taken for server-side objects to delete.
+func beginRun(ctx context.Context, c *Client, what string, ch changeOptions) (RebalanceNodePoolList, RebalanceNodeClassList, *runState) {
+	run := &runState{what: what}
+	if ch.Resume == "" {
+		serverNodePools, serverNodeClasses := listServer(ctx, c)
+		return serverNodePools, serverNodeClasses, run
+	}
+	j, err := resumeJournal(ch.Resume, c.ClusterID, what)
 	if err != nil {
-		panic(fmt.Errorf("failed to create config: %v", err))
+		fatalf(exitError, "can't resume: %v", err)
 	}
+	snapshot, err := readSnapshot(j.Backup)
+	if err != nil {
+		fatalf(exitError, "can't resume, failed to read the backup of the first run: %v", err)
+	}
+	run.snapshot, run.backupPath, run.journal = snapshot, j.Backup, j
+	klog.Infof("resuming %s from %s: %d step(s) already done, starting from the server side as backed up at %s",
+		what, j.Path, len(j.done), snapshot.CreatedAt.Format(time.RFC3339))
+	serverNodePools, serverNodeClasses := snapshot.lists()
+	return serverNodePools, serverNodeClasses, run
+}
 
-	kubeClient, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+// mustStart writes the server-side config to a file under backupDir and starts
+// the journal next to it, and exits if it can't, so nothing is changed without
+// a copy. A resumed run keeps the backup and journal of its first run.
+func (r *runState) mustStart(c *Client, backupDir string, serverNodePools RebalanceNodePoolList, serverNodeClasses RebalanceNodeClassList) {
+	if r.journal != nil {
+		return
+	}
+	snapshot := newServerSnapshot(c.ClusterID, serverNodePools, serverNodeClasses)
+	backupPath, err := writeBackup(backupDir, snapshot)
 	if err != nil {
-		panic(fmt.Errorf("failed to create client: %v", err))
+		fatalf(exitError, "backup failed, nothing changed: %v", err)
 	}
-	c := NewCloudPilotClient(ak, clusterID)
+	klog.Infof("server-side config backed up to %s, restore it with: restore --from %s", backupPath, backupPath)
+	j, err := createJournal(backupDir, c.ClusterID, r.what, backupPath, snapshot.CreatedAt)
+	if err != nil {
+		fatalf(exitError, "journal failed, nothing changed: %v", err)
+	}
+	klog.Infof("steps are recorded in %s, finish an interrupted run with: %s --resume %s", j.Path, r.what, j.Path)
+	r.snapshot, r.backupPath, r.journal = snapshot, backupPath, j
+}
 
-	// Require explicit "delete"
-	if !requireExactInput("Type 'delete' to DELETE the current NodePools & NodeClasses on the server side, or anything else to skip: ", "delete") {
-		klog.Infof("delete skipped by user; migration left original objects intact")
+// applyChanges runs the steps of a run that has written its backup and prints
+// the result of each. If any step failed, the run is rolled back from the
+// backup unless --no-rollback was given. An interrupted run stops after the
+// in-flight steps and is left as it is, so it can be resumed or restored.
+func applyChanges(ctx context.Context, c *Client, run *runState, steps []step, ch changeOptions) {
+	results, err := runSteps(ctx, steps, ch.Concurrency, run.journal)
+	printStepReport("Steps", steps, results)
+	if err == nil {
+		run.journal.Close()
+		if !ch.SkipVerify {
+			mustVerify(ctx, c, run, steps, results)
+		}
+		klog.Infof("%s finished successfully", run.what)
 		return
 	}
-	// Delete from cluster
-	if err := deleteAll(c); err != nil {
-		fmt.Fprintf(os.Stderr, "error: delete failed: %v\n", err)
-		os.Exit(2)
+	resume := fmt.Sprintf("%s --resume %s", run.what, run.journal.Path)
+	if errors.Is(err, errInterrupted) {
+		run.journal.Close()
+		fatalf(exitAborted, "%s interrupted, nothing was rolled back; finish it with: %s or restore with: restore --from %s", run.what, resume, run.backupPath)
 	}
-	klog.Infof("delete finished successfully")
 
-	var nodepoolList alibabacloudcorev1.NodePoolList
-	if err := kubeClient.List(context.Background(), &nodepoolList); err != nil {
-		panic(fmt.Errorf("failed to list nodepools: %v", err))
+	if isAuthError(err) {
+		// a rollback would be refused the same way
+		run.journal.Close()
+		fatalAPIf(exitError, err, "%s failed, nothing was rolled back; once the key is fixed, finish it with: %s or restore with: restore --from %s", run.what, resume, run.backupPath)
 	}
-	var nodeclassList alibabacloudproviderv1alpha1.ECSNodeClassList
-	if err := kubeClient.List(context.Background(), &nodeclassList); err != nil {
-		panic(fmt.Errorf("failed to list nodeclasses: %v", err))
+	fmt.Fprintf(os.Stderr, "error: %s failed: %v\n", run.what, err)
+	if hint := apiErrorHint(err); hint != "" {
+		fmt.Fprintf(os.Stderr, "hint: %s\n", hint)
 	}
+	code := exitUploadFailed
+	if hasFailed(steps, results, "delete") {
+		code = exitDeleteFailed
+	}
+	if ch.NoRollback {
+		run.journal.Close()
+		fatalf(code, "nothing was rolled back (--no-rollback); finish it with: %s or restore with: restore --from %s", resume, run.backupPath)
+	}
+	rollbackAndExit(ctx, c, run, uploadedBy(steps, results), ch.Concurrency, code)
+}
 
-	// Preview tables
-	printPreviewTables(nodepoolList.Items, nodeclassList.Items)
+// mustVerify checks that the server stores what the run should have left
+// there, since a 200 only means each request was accepted. Drift is reported
+// but not rolled back, the run itself went through.
+func mustVerify(ctx context.Context, c *Client, run *runState, steps []step, results []stepResult) {
+	report, err := verifyServer(ctx, c, run.snapshot, steps, results)
+	if err != nil {
+		fatalAPIf(exitVerifyFailed, err, "%s went through but verifying the server failed", run.what)
+	}
+	printVerifyReport(report)
+	if n := report.drift(); n > 0 {
+		fatalf(exitVerifyFailed, "%s went through but %d object(s) on the server are not as expected; the config before the run is in %s", run.what, n, run.backupPath)
+	}
+}
+
+// rollbackAndExit puts the pre-migration server state back after a failed
+// delete, upload or reconcile, reports what was rolled back and exits with code.
+// Like the run itself, the rollback stops after its in-flight steps on SIGINT
+// and reports which objects it restored.
+func rollbackAndExit(ctx context.Context, c *Client, run *runState, done uploadedObjects, concurrency, code int) {
+	klog.Infof("rolling back to the server-side config captured before the migration")
+	steps := rollbackSteps(c, done, run.snapshot)
+	// The rollback must run even when the overall deadline is what stopped the run
+	results, err := runSteps(context.WithoutCancel(ctx), steps, concurrency, nil)
+	printStepReport("Rollback", steps, results)
+	if err := run.journal.recordRollback(err == nil); err != nil {
+		klog.Errorf("failed to record the rollback in the journal: %v", err)
+	}
+	run.journal.Close()
+	if errors.Is(err, errInterrupted) {
+		fatalf(code, "rollback interrupted, the server is only partly restored; finish it with: restore --from %s", run.backupPath)
+	}
+	if err != nil {
+		fatalf(code, "rollback did NOT fully succeed, retry with: restore --from %s", run.backupPath)
+	}
+	fmt.Fprintln(os.Stderr, "rollback succeeded; the server-side config is back to its pre-migration state")
+	os.Exit(code)
+}
+
+// runRestore re-applies a backup written by migrate.
+func runRestore(ctx context.Context, c *Client, from string, ch changeOptions) {
+	snapshot, err := readSnapshot(from)
+	if err != nil {
+		fatalf(exitError, "failed to read backup: %v", err)
+	}
+	if snapshot.ClusterID != c.ClusterID {
+		fatalf(exitError, "backup %s belongs to cluster %q, not %q", from, snapshot.ClusterID, c.ClusterID)
+	}
+
+	fmt.Printf("\nBackup of cluster %s taken at %s\n", snapshot.ClusterID, snapshot.CreatedAt.Format(time.RFC3339))
+	printNodePools("NodePools to RESTORE", snapshot.NodePools)
+	printNodeClasses("NodeClasses to RESTORE", snapshot.NodeClasses)
 
-	// Require explicit "upload"
-	if !requireExactInput("Type 'upload' to start uploading to CloudPilot AI, or anything else to abort: ", "upload") {
-		klog.Infof("aborted by user; nothing uploaded, nothing deleted")
+	if !ch.Confirm.confirm("Type 'restore' to re-apply the backup above to CloudPilot AI, or anything else to abort: ", "restore", false, true) {
+		fatalf(exitAborted, "aborted by user; nothing restored")
+	}
+	steps := restoreSteps(c, snapshot)
+	results, err := runSteps(ctx, steps, ch.Concurrency, nil)
+	printStepReport("Steps", steps, results)
+	if err != nil {
+		if errors.Is(err, errInterrupted) {
+			fatalf(exitAborted, "restore interrupted, run it again to finish")
+		}
+		fatalAPIf(exitUploadFailed, err, "restore failed")
+	}
+	klog.Infof("restore finished successfully")
+}
+
+// orphanPolicy is what to do with NodeClasses no NodePool references.
+type orphanPolicy struct {
+	include bool
+	skip    bool
+}
+
+// mustCheckReferences stops before anything is changed on dangling
+// nodeClassRefs, or on orphaned NodeClasses when no policy was given. It
+// returns the NodeClasses to upload.
+func mustCheckReferences(nodepools []RebalanceNodePool, nodeclasses []RebalanceNodeClass, kept serverKept, orphans orphanPolicy) []RebalanceNodeClass {
+	report := checkReferences(nodepools, nodeclasses, kept)
+	printRefReport(report)
+	if len(report.Dangling) > 0 {
+		fatalf(exitValidationFailed, "%d NodePool(s) with dangling nodeClassRef, nothing changed", len(report.Dangling))
+	}
+	if len(report.Orphans) == 0 {
+		return nodeclasses
+	}
+	switch {
+	case orphans.skip:
+		klog.Infof("skipping %d unreferenced NodeClass(es): %v", len(report.Orphans), report.Orphans)
+		return withoutNodeClasses(nodeclasses, report.Orphans)
+	case orphans.include:
+		return nodeclasses
+	}
+	fatalf(exitValidationFailed, "%d NodeClass(es) not referenced by any NodePool, pass --include-orphans or --skip-orphans", len(report.Orphans))
+	return nil
+}
+
+// mustAcceptConversion stops before anything is changed when v1beta1 fields
+// would be lost on the way to the server, unless that was accepted.
+func mustAcceptConversion(issues []conversionIssue, accept bool) {
+	if len(issues) == 0 {
+		return
+	}
+	printConversionIssues(issues)
+	if accept {
+		klog.Warningf("uploading %d NodePool field(s) that don't convert to v1 as listed above, as accepted by --accept-conversion-issues", len(issues))
 		return
 	}
+	fatalf(exitValidationFailed, "%d v1beta1 field(s) would be lost in the conversion to v1, fix them or pass --accept-conversion-issues; nothing changed", len(issues))
+}
 
-	// Upload to CloudPilot
-	if err := uploadAll(c, nodeclassList.Items, nodepoolList.Items); err != nil {
-		fmt.Fprintf(os.Stderr, "error: upload failed: %v\n", err)
-		os.Exit(2)
+// mustValidate stops before anything is changed if a NodeClass would be
+// rejected by its CRD rules.
+func mustValidate(nodeclasses []RebalanceNodeClass) {
+	errs := validateNodeClasses(nodeclasses)
+	if len(errs) == 0 {
+		return
+	}
+	printValidationErrors(errs)
+	fatalf(exitValidationFailed, "%d validation error(s), nothing changed", len(errs))
+}
+
+func listServer(ctx context.Context, c *Client) (RebalanceNodePoolList, RebalanceNodeClassList) {
+	serverNodePools, err := c.ListClusterRebalanceNodePools(ctx)
+	if err != nil {
+		fatalAPIf(exitError, err, "failed to list server nodepools")
+	}
+	serverNodeClasses, err := c.ListClusterRebalanceNodeClasses(ctx)
+	if err != nil {
+		fatalAPIf(exitError, err, "failed to list server nodeclasses")
 	}
-	klog.Infof("upload finished successfully")
+	return serverNodePools, serverNodeClasses
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+type planAction string
+
+const (
+	planCreate    planAction = "create"
+	planUpdate    planAction = "update"
+	planUnchanged planAction = "unchanged"
+	// planDelete marks an object that only exists on the server.
+	planDelete planAction = "delete"
+)
+
+// fieldDiff is a single changed leaf, addressed by its JSON path.
+// A nil Server means the field is added, a nil Cluster means it is removed.
+type fieldDiff struct {
+	Path    string
+	Server  any
+	Cluster any
+}
+
+type planItem struct {
+	Name   string
+	Action planAction
+	Diffs  []fieldDiff
+}
+
+type migrationPlan struct {
+	NodePools   []planItem
+	NodeClasses []planItem
+}
+
+// buildPlan classifies every object on either side by comparing what would be
+// uploaded from the cluster with what the server currently stores.
+func buildPlan(
+	serverNodePools []RebalanceNodePool,
+	serverNodeClasses []RebalanceNodeClass,
+	nodepools []RebalanceNodePool,
+	nodeclasses []RebalanceNodeClass,
+) migrationPlan {
+	serverPools := make(map[string]any, len(serverNodePools))
+	for _, np := range serverNodePools {
+		serverPools[np.GetName()] = np.object()
+	}
+	clusterPools := make(map[string]any, len(nodepools))
+	for _, np := range nodepools {
+		clusterPools[np.GetName()] = np.object()
+	}
+	serverClasses := make(map[string]any, len(serverNodeClasses))
+	for _, nc := range serverNodeClasses {
+		serverClasses[nc.GetName()] = nc.object()
+	}
+	clusterClasses := make(map[string]any, len(nodeclasses))
+	for _, nc := range nodeclasses {
+		clusterClasses[nc.GetName()] = nc.object()
+	}
+
+	return migrationPlan{
+		NodePools:   planItems(serverPools, clusterPools),
+		NodeClasses: planItems(serverClasses, clusterClasses),
+	}
+}
+
+func planItems(server, cluster map[string]any) []planItem {
+	names := make(map[string]struct{}, len(server)+len(cluster))
+	for name := range server {
+		names[name] = struct{}{}
+	}
+	for name := range cluster {
+		names[name] = struct{}{}
+	}
+
+	items := make([]planItem, 0, len(names))
+	for name := range names {
+		s, onServer := server[name]
+		c, inCluster := cluster[name]
+		item := planItem{Name: name}
+		switch {
+		case !onServer:
+			item.Action = planCreate
+		case !inCluster:
+			item.Action = planDelete
+		default:
+			item.Diffs = diffJSON(s, c)
+			item.Action = planUnchanged
+			if len(item.Diffs) > 0 {
+				item.Action = planUpdate
+			}
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+// diffJSON compares two values by their JSON form, so that only differences
+// visible on the wire are reported.
+func diffJSON(server, cluster any) []fieldDiff {
+	var diffs []fieldDiff
+	walkDiff("", normalizeJSON(server), normalizeJSON(cluster), &diffs)
+	return diffs
+}
+
+func normalizeJSON(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<marshal error: %v>", err)
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return fmt.Sprintf("<unmarshal error: %v>", err)
+	}
+	return out
+}
+
+func walkDiff(path string, server, cluster any, diffs *[]fieldDiff) {
+	switch s := server.(type) {
+	case map[string]any:
+		c, ok := cluster.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]struct{}, len(s)+len(c))
+		for k := range s {
+			keys[k] = struct{}{}
+		}
+		for k := range c {
+			keys[k] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			walkDiff(joinPath(path, k), s[k], c[k], diffs)
+		}
+		return
+	case []any:
+		c, ok := cluster.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(s) || i < len(c); i++ {
+			var sv, cv any
+			if i < len(s) {
+				sv = s[i]
+			}
+			if i < len(c) {
+				cv = c[i]
+			}
+			walkDiff(fmt.Sprintf("%s[%d]", path, i), sv, cv, diffs)
+		}
+		return
+	}
+	if compactJSON(server) != compactJSON(cluster) {
+		*diffs = append(*diffs, fieldDiff{Path: path, Server: server, Cluster: cluster})
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (p migrationPlan) counts() map[planAction]int {
+	counts := map[planAction]int{}
+	for _, it := range p.NodePools {
+		counts[it.Action]++
+	}
+	for _, it := range p.NodeClasses {
+		counts[it.Action]++
+	}
+	return counts
+}
+
+func printPlan(p migrationPlan) {
+	printPlanSection("NodePools", p.NodePools)
+	printPlanSection("NodeClasses", p.NodeClasses)
+
+	counts := p.counts()
+	fmt.Printf("\nPlan: %d to create, %d to update, %d unchanged, %d only on server (delete)\n",
+		counts[planCreate], counts[planUpdate], counts[planUnchanged], counts[planDelete])
+}
+
+func printPlanSection(title string, items []planItem) {
+	fmt.Printf("\n=== %s Plan ===\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tACTION\tCHANGED FIELDS")
+	for _, it := range items {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", it.Name, it.Action, len(it.Diffs))
+	}
+	w.Flush()
+
+	for _, it := range items {
+		if len(it.Diffs) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s:\n", it.Name)
+		for _, d := range it.Diffs {
+			fmt.Println(formatFieldDiff(d))
+		}
+	}
+}
+
+func formatFieldDiff(d fieldDiff) string {
+	switch {
+	case d.Server == nil:
+		return fmt.Sprintf("  + %s: %s", d.Path, trim(compactJSON(d.Cluster), 120))
+	case d.Cluster == nil:
+		return fmt.Sprintf("  - %s: %s", d.Path, trim(compactJSON(d.Server), 120))
+	default:
+		return fmt.Sprintf("  ~ %s: %s -> %s", d.Path,
+			trim(compactJSON(d.Server), 60), trim(compactJSON(d.Cluster), 60))
+	}
+}
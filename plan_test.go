@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  any
+		cluster any
+		want    []fieldDiff
+	}{
+		{
+			name:    "equal",
+			server:  map[string]any{"a": 1, "b": []any{"x"}},
+			cluster: map[string]any{"b": []any{"x"}, "a": 1},
+		},
+		{
+			name:    "changed leaf",
+			server:  map[string]any{"spec": map[string]any{"weight": 1}},
+			cluster: map[string]any{"spec": map[string]any{"weight": 2}},
+			want:    []fieldDiff{{Path: "spec.weight", Server: 1.0, Cluster: 2.0}},
+		},
+		{
+			name:    "added and removed fields in key order",
+			server:  map[string]any{"b": "old"},
+			cluster: map[string]any{"a": "new"},
+			want: []fieldDiff{
+				{Path: "a", Cluster: "new"},
+				{Path: "b", Server: "old"},
+			},
+		},
+		{
+			name:    "list element changed and appended",
+			server:  map[string]any{"tags": []any{"a", "b"}},
+			cluster: map[string]any{"tags": []any{"a", "c", "d"}},
+			want: []fieldDiff{
+				{Path: "tags[1]", Server: "b", Cluster: "c"},
+				{Path: "tags[2]", Cluster: "d"},
+			},
+		},
+		{
+			name:    "type changed",
+			server:  map[string]any{"a": map[string]any{"b": 1}},
+			cluster: map[string]any{"a": "b"},
+			want:    []fieldDiff{{Path: "a", Server: map[string]any{"b": 1.0}, Cluster: "b"}},
+		},
+		{
+			// only what is visible on the wire is compared
+			name:    "struct and map with the same JSON",
+			server:  struct{ Name string }{"x"},
+			cluster: map[string]any{"Name": "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffJSON(tt.server, tt.cluster)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatFieldDiff(t *testing.T) {
+	tests := []struct {
+		diff fieldDiff
+		want string
+	}{
+		{fieldDiff{Path: "a", Cluster: "new"}, `  + a: "new"`},
+		{fieldDiff{Path: "b", Server: 1.0}, `  - b: 1`},
+		{fieldDiff{Path: "spec.weight", Server: 1.0, Cluster: 2.0}, `  ~ spec.weight: 1 -> 2`},
+	}
+	for _, tt := range tests {
+		if got := formatFieldDiff(tt.diff); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestBuildPlan(t *testing.T) {
+	disabled := testNodePool("changed", "x")
+	disabled.ECSNodePool.Enable = false
+
+	plan := buildPlan(
+		[]RebalanceNodePool{testNodePool("same", "x"), disabled, testNodePool("server-only", "x")},
+		[]RebalanceNodeClass{testNodeClass("x"), testNodeClass("stale")},
+		[]RebalanceNodePool{testNodePool("same", "x"), testNodePool("changed", "x"), testNodePool("new", "y")},
+		[]RebalanceNodeClass{testNodeClass("x"), testNodeClass("y")},
+	)
+
+	type action struct {
+		Name   string
+		Action planAction
+	}
+	actions := func(items []planItem) []action {
+		out := make([]action, len(items))
+		for i, it := range items {
+			out[i] = action{it.Name, it.Action}
+		}
+		return out
+	}
+	wantPools := []action{
+		{"changed", planUpdate},
+		{"new", planCreate},
+		{"same", planUnchanged},
+		{"server-only", planDelete},
+	}
+	if got := actions(plan.NodePools); !reflect.DeepEqual(got, wantPools) {
+		t.Errorf("nodepools = %v, want %v", got, wantPools)
+	}
+	wantClasses := []action{
+		{"stale", planDelete},
+		{"x", planUnchanged},
+		{"y", planCreate},
+	}
+	if got := actions(plan.NodeClasses); !reflect.DeepEqual(got, wantClasses) {
+		t.Errorf("nodeclasses = %v, want %v", got, wantClasses)
+	}
+
+	wantDiffs := []fieldDiff{{Path: "enable", Server: false, Cluster: true}}
+	if got := plan.NodePools[0].Diffs; !reflect.DeepEqual(got, wantDiffs) {
+		t.Errorf("diffs of changed = %+v, want %+v", got, wantDiffs)
+	}
+
+	counts := plan.counts()
+	want := map[planAction]int{planCreate: 2, planUpdate: 1, planUnchanged: 2, planDelete: 2}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+}
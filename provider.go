@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/values"
+	alibabacloudproviderv1alpha1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis/v1alpha1"
+	alibabacloudcorev1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1"
+	alibabacloudcorev1beta1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1beta1"
+	awsproviderv1 "github.com/cloudpilot-ai/lib/pkg/aws/karpenter-provider-aws/apis/v1"
+	awscorev1 "github.com/cloudpilot-ai/lib/pkg/aws/karpenter/apis/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newScheme registers the Karpenter types of a single provider. Both providers
+// serve karpenter.sh/v1 NodePool with different Go types, so they can't share one.
+func newScheme(provider string) (*runtime.Scheme, error) {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		return nil, err
+	}
+	switch provider {
+	case values.CloudProviderAlibabaCloud:
+		if err := alibabacloudproviderv1alpha1.SchemeBuilder.AddToScheme(s); err != nil {
+			return nil, err
+		}
+		if err := alibabacloudcorev1.SchemeBuilder.AddToScheme(s); err != nil {
+			return nil, err
+		}
+		s.AddKnownTypes(alibabacloudCoreV1beta1, &alibabacloudcorev1beta1.NodePool{}, &alibabacloudcorev1beta1.NodePoolList{})
+		metav1.AddToGroupVersion(s, alibabacloudCoreV1beta1)
+	case values.CloudProviderAWS:
+		if err := awsproviderv1.SchemeBuilder.AddToScheme(s); err != nil {
+			return nil, err
+		}
+		if err := awscorev1.SchemeBuilder.AddToScheme(s); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported provider %q, must be one of %v", provider, values.AllowedClusterProvider)
+	}
+	return s, nil
+}
+
+// detectProvider picks the provider whose NodeClass CRD is served by the cluster.
+func detectProvider(cfg *rest.Config) (string, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return "", fmt.Errorf("create discovery client: %w", err)
+	}
+
+	var found []string
+	for _, p := range []struct {
+		provider string
+		gv       schema.GroupVersion
+		kind     string
+	}{
+		{values.CloudProviderAlibabaCloud, alibabacloudproviderv1alpha1.SchemeGroupVersion, "ECSNodeClass"},
+		{values.CloudProviderAWS, awsproviderv1.SchemeGroupVersion, "EC2NodeClass"},
+	} {
+		served, err := servesKind(dc, p.gv, p.kind)
+		if err != nil {
+			return "", err
+		}
+		if served {
+			found = append(found, p.provider)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("neither ECSNodeClass nor EC2NodeClass CRDs are installed, pass --provider explicitly")
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("found CRDs for %v, pass --provider explicitly", found)
+	}
+}
+
+// servesKind reports whether the cluster serves kind in gv. Only NotFound
+// means it doesn't; an unreachable or unauthorized API server is an error.
+func servesKind(dc discovery.DiscoveryInterface, gv schema.GroupVersion, kind string) (bool, error) {
+	resources, err := dc.ServerResourcesForGroupVersion(gv.String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("discover %s: %w", gv, err)
+	}
+	for _, r := range resources.APIResources {
+		if r.Kind == kind {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listCluster lists the provider's NodePools and NodeClasses, converts them
+// to the envelopes that are uploaded to the server and keeps the selected ones.
+// The v1beta1 conversion issues of the selected NodePools are returned too.
+func listCluster(ctx context.Context, kubeClient client.Client, provider string, sel selection) ([]RebalanceNodePool, []RebalanceNodeClass, []conversionIssue) {
+	var (
+		nodepools   []RebalanceNodePool
+		nodeclasses []RebalanceNodeClass
+		poolLabels  []map[string]string
+		classLabels []map[string]string
+		issues      []conversionIssue
+	)
+	switch provider {
+	case values.CloudProviderAWS:
+		var nodepoolList awscorev1.NodePoolList
+		if err := kubeClient.List(ctx, &nodepoolList); err != nil {
+			fatalf(exitError, "failed to list nodepools: %v", err)
+		}
+		var nodeclassList awsproviderv1.EC2NodeClassList
+		if err := kubeClient.List(ctx, &nodeclassList); err != nil {
+			fatalf(exitError, "failed to list nodeclasses: %v", err)
+		}
+		for i := range nodepoolList.Items {
+			nodepools = append(nodepools, desiredEC2NodePool(&nodepoolList.Items[i]))
+			poolLabels = append(poolLabels, nodepoolList.Items[i].Labels)
+		}
+		for i := range nodeclassList.Items {
+			nodeclasses = append(nodeclasses, desiredEC2NodeClass(&nodeclassList.Items[i]))
+			classLabels = append(classLabels, nodeclassList.Items[i].Labels)
+		}
+	default:
+		nodepoolList, npIssues, err := listAlibabaCloudNodePools(ctx, kubeClient)
+		issues = npIssues
+		if err != nil {
+			fatalf(exitError, "failed to list nodepools: %v", err)
+		}
+		var nodeclassList alibabacloudproviderv1alpha1.ECSNodeClassList
+		if err := kubeClient.List(ctx, &nodeclassList); err != nil {
+			fatalf(exitError, "failed to list nodeclasses: %v", err)
+		}
+		for i := range nodepoolList.Items {
+			nodepools = append(nodepools, desiredECSNodePool(&nodepoolList.Items[i]))
+			poolLabels = append(poolLabels, nodepoolList.Items[i].Labels)
+		}
+		for i := range nodeclassList.Items {
+			nodeclasses = append(nodeclasses, desiredECSNodeClass(&nodeclassList.Items[i]))
+			classLabels = append(classLabels, nodeclassList.Items[i].Labels)
+		}
+	}
+	nodepools, nodeclasses = sel.filterCluster(nodepools, poolLabels, nodeclasses, classLabels)
+	return nodepools, nodeclasses, selectedIssues(issues, nodepools)
+}
+
+// selectedIssues keeps the conversion issues of the given NodePools.
+func selectedIssues(issues []conversionIssue, nodepools []RebalanceNodePool) []conversionIssue {
+	names := make(map[string]struct{}, len(nodepools))
+	for _, np := range nodepools {
+		names[np.GetName()] = struct{}{}
+	}
+	var out []conversionIssue
+	for _, is := range issues {
+		if _, ok := names[is.NodePool]; ok {
+			out = append(out, is)
+		}
+	}
+	return out
+}
+
+// listAlibabaCloudNodePools lists karpenter.sh/v1 NodePools, falling back to
+// v1beta1 on clusters that don't serve v1 yet. v1beta1 objects are converted
+// and every field that could not be converted is returned as an issue.
+func listAlibabaCloudNodePools(ctx context.Context, kubeClient client.Client) (alibabacloudcorev1.NodePoolList, []conversionIssue, error) {
+	var nodepoolList alibabacloudcorev1.NodePoolList
+	err := kubeClient.List(ctx, &nodepoolList)
+	if err == nil || !meta.IsNoMatchError(err) {
+		return nodepoolList, nil, err
+	}
+
+	klog.Infof("karpenter.sh/v1 NodePools are not served, listing %s", alibabacloudCoreV1beta1)
+	var v1beta1List alibabacloudcorev1beta1.NodePoolList
+	if err := kubeClient.List(ctx, &v1beta1List); err != nil {
+		return nodepoolList, nil, err
+	}
+	var issues []conversionIssue
+	for i := range v1beta1List.Items {
+		np, npIssues := convertV1beta1NodePool(&v1beta1List.Items[i])
+		nodepoolList.Items = append(nodepoolList.Items, *np)
+		issues = append(issues, npIssues...)
+	}
+	return nodepoolList, issues, nil
+}
+
+func printConversionIssues(issues []conversionIssue) {
+	if len(issues) == 0 {
+		return
+	}
+	fmt.Println("\n=== v1beta1 -> v1 conversion issues ===")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODEPOOL\tFIELD\tREASON")
+	for _, is := range issues {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", is.NodePool, is.Field, is.Reason)
+	}
+	w.Flush()
+}
+
+// desiredECSNodePool is the server-side shape uploaded for an in-cluster Alibaba Cloud NodePool.
+func desiredECSNodePool(np *alibabacloudcorev1.NodePool) RebalanceNodePool {
+	return RebalanceNodePool{ECSNodePool: &ECSNodePool{
+		Name:         np.Name,
+		Enable:       true,
+		NodePoolSpec: &np.Spec,
+	}}
+}
+
+// desiredECSNodeClass is the server-side shape uploaded for an in-cluster ECSNodeClass.
+func desiredECSNodeClass(nc *alibabacloudproviderv1alpha1.ECSNodeClass) RebalanceNodeClass {
+	return RebalanceNodeClass{ECSNodeClass: &ECSNodeClass{
+		Name:          nc.Name,
+		NodeClassSpec: &nc.Spec,
+	}}
+}
+
+// desiredEC2NodePool is the server-side shape uploaded for an in-cluster AWS NodePool.
+func desiredEC2NodePool(np *awscorev1.NodePool) RebalanceNodePool {
+	return RebalanceNodePool{EC2NodePool: &EC2NodePool{
+		Name:               np.Name,
+		Enable:             true,
+		NodePoolAnnotation: compatAnnotations(np.Annotations),
+		NodePoolSpec:       &np.Spec,
+	}}
+}
+
+// desiredEC2NodeClass is the server-side shape uploaded for an in-cluster EC2NodeClass.
+func desiredEC2NodeClass(nc *awsproviderv1.EC2NodeClass) RebalanceNodeClass {
+	return RebalanceNodeClass{EC2NodeClass: &EC2NodeClass{
+		Name:                nc.Name,
+		NodeClassAnnotation: compatAnnotations(nc.Annotations),
+		NodeClassSpec:       &nc.Spec,
+	}}
+}
+
+// compatAnnotations is the annotation map uploaded with an EC2 object. The
+// kubectl last-applied copy of the object is left out, and an object without
+// annotations sends none so it compares equal to what the server returns.
+func compatAnnotations(annotations map[string]string) map[string]string {
+	out := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		if k != corev1.LastAppliedConfigAnnotation {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
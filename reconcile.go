@@ -0,0 +1,77 @@
+package main
+
+// reconcileScope returns the server-side NodeClasses reconcile compares the
+// cluster against: scopedClasses without those left out of the upload, like
+// orphans under --skip-orphans, which stay in the cluster and so must not be
+// pruned from the server.
+func reconcileScope(scopedClasses, clusterClasses, nodeclasses []RebalanceNodeClass) []RebalanceNodeClass {
+	uploaded := make(map[string]struct{}, len(nodeclasses))
+	for _, nc := range nodeclasses {
+		uploaded[nc.GetName()] = struct{}{}
+	}
+	var left []string
+	for _, nc := range clusterClasses {
+		if _, ok := uploaded[nc.GetName()]; !ok {
+			left = append(left, nc.GetName())
+		}
+	}
+	return withoutNodeClasses(scopedClasses, left)
+}
+
+// reconcileSteps brings the server in line with the cluster according to the
+// plan. Only new or changed objects are applied, NodeClasses before the
+// NodePools that reference them; unchanged objects are never touched and stay
+// live. Server-only objects are deleted, a NodeClass after the NodePools that
+// reference it, only when prune is set.
+func reconcileSteps(
+	c *Client,
+	p migrationPlan,
+	serverNodePools []RebalanceNodePool,
+	nodeclasses []RebalanceNodeClass,
+	nodepools []RebalanceNodePool,
+	prune bool,
+) []step {
+	classes := make(map[string]RebalanceNodeClass, len(nodeclasses))
+	for _, nc := range nodeclasses {
+		classes[nc.GetName()] = nc
+	}
+	pools := make(map[string]RebalanceNodePool, len(nodepools))
+	for _, np := range nodepools {
+		pools[np.GetName()] = np
+	}
+
+	var steps []step
+	applied := map[string]struct{}{}
+	for _, it := range p.NodeClasses {
+		if it.Action == planCreate || it.Action == planUpdate {
+			steps = append(steps, applyNodeClassStep(c, classes[it.Name]))
+			applied[it.Name] = struct{}{}
+		}
+	}
+	for _, it := range p.NodePools {
+		if it.Action == planCreate || it.Action == planUpdate {
+			steps = append(steps, applyNodePoolStep(c, pools[it.Name], applyNodePoolAfter(pools[it.Name], applied)))
+		}
+	}
+
+	if !prune {
+		return steps
+	}
+	serverPools := make(map[string]RebalanceNodePool, len(serverNodePools))
+	for _, np := range serverNodePools {
+		serverPools[np.GetName()] = np
+	}
+	var pruned []RebalanceNodePool
+	for _, it := range p.NodePools {
+		if it.Action == planDelete {
+			steps = append(steps, deleteNodePoolStep(c, it.Name))
+			pruned = append(pruned, serverPools[it.Name])
+		}
+	}
+	for _, it := range p.NodeClasses {
+		if it.Action == planDelete {
+			steps = append(steps, deleteNodeClassStep(c, it.Name, deleteNodeClassAfter(it.Name, pruned)))
+		}
+	}
+	return steps
+}
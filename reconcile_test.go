@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	alibabacloudproviderapis "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis"
+	alibabacloudcorev1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1"
+)
+
+// testNodePool is an enabled ECS NodePool that references the ECSNodeClass class.
+func testNodePool(name, class string) RebalanceNodePool {
+	spec := &alibabacloudcorev1.NodePoolSpec{}
+	spec.Template.Spec.NodeClassRef = &alibabacloudcorev1.NodeClassReference{
+		Group: alibabacloudproviderapis.Group,
+		Kind:  "ECSNodeClass",
+		Name:  class,
+	}
+	return RebalanceNodePool{ECSNodePool: &ECSNodePool{Name: name, Enable: true, NodePoolSpec: spec}}
+}
+
+func testNodeClass(name string) RebalanceNodeClass {
+	return RebalanceNodeClass{ECSNodeClass: &ECSNodeClass{Name: name, NodeClassSpec: validECSNodeClassSpec()}}
+}
+
+func stepNames(steps []step) []string {
+	names := make([]string, len(steps))
+	for i, s := range steps {
+		names[i] = s.String()
+	}
+	return names
+}
+
+func TestReconcilePruneKeepsSkippedOrphans(t *testing.T) {
+	serverPools := []RebalanceNodePool{testNodePool("a", "used")}
+	serverClasses := []RebalanceNodeClass{testNodeClass("used"), testNodeClass("orphan"), testNodeClass("stale")}
+	nodepools := []RebalanceNodePool{testNodePool("a", "used")}
+	clusterClasses := []RebalanceNodeClass{testNodeClass("used"), testNodeClass("orphan")}
+
+	// --skip-orphans --prune
+	kept := keptOnServer(serverPools, serverClasses, serverPools, serverClasses, nodepools)
+	report := checkReferences(nodepools, clusterClasses, kept)
+	if len(report.Dangling) != 0 || len(report.Orphans) != 1 || report.Orphans[0] != "orphan" {
+		t.Fatalf("report = %+v, want the orphan", report)
+	}
+	nodeclasses := withoutNodeClasses(clusterClasses, report.Orphans)
+
+	scopedClasses := reconcileScope(serverClasses, clusterClasses, nodeclasses)
+	p := buildPlan(serverPools, scopedClasses, nodepools, nodeclasses)
+	steps := reconcileSteps(&Client{}, p, serverPools, nodeclasses, nodepools, true)
+	want := []string{stepKey("delete", "NodeClass", "stale")}
+	if got := stepNames(steps); len(got) != len(want) || got[0] != want[0] {
+		t.Fatalf("steps = %v, want %v", got, want)
+	}
+}
+
+func TestReconcilePreservesDisabledPools(t *testing.T) {
+	serverPools := []RebalanceNodePool{testNodePool("a", "x").WithEnable(false)}
+	serverClasses := []RebalanceNodeClass{testNodeClass("x")}
+	nodepools, err := enablePolicy{Mode: enablePreserve}.apply([]RebalanceNodePool{testNodePool("a", "x")}, serverPools)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	p := buildPlan(serverPools, serverClasses, nodepools, serverClasses)
+	if steps := reconcileSteps(&Client{}, p, serverPools, serverClasses, nodepools, true); len(steps) != 0 {
+		t.Fatalf("steps = %v, want none", stepNames(steps))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// danglingRef is a NodePool whose nodeClassRef can't be satisfied.
+type danglingRef struct {
+	NodePool string
+	Ref      string
+	Reason   string
+}
+
+type refReport struct {
+	Dangling []danglingRef
+	// Orphans are uploaded NodeClasses that no NodePool references, uploaded
+	// or kept on the server.
+	Orphans []string
+}
+
+// serverKept is what a run leaves on the server besides what it uploads.
+type serverKept struct {
+	// NodePools stay on the server as they are and keep referencing their NodeClass.
+	NodePools   []RebalanceNodePool
+	NodeClasses []RebalanceNodeClass
+	// Deleted are the server-side NodeClasses the run deletes.
+	Deleted []string
+}
+
+// keptOnServer is the server side less what a run deletes; server NodePools
+// that an upload overwrites don't stay either.
+func keptOnServer(serverNodePools []RebalanceNodePool, serverNodeClasses []RebalanceNodeClass, deletedPools []RebalanceNodePool, deletedClasses []RebalanceNodeClass, uploaded []RebalanceNodePool) serverKept {
+	return serverKept{
+		NodePools:   withoutNodePools(serverNodePools, append(nodePoolNames(deletedPools), nodePoolNames(uploaded)...)),
+		NodeClasses: withoutNodeClasses(serverNodeClasses, nodeClassNames(deletedClasses)),
+		Deleted:     nodeClassNames(deletedClasses),
+	}
+}
+
+// checkReferences verifies that every uploaded NodePool references a NodeClass
+// of the right group and kind that is either uploaded or kept on the server,
+// and that no NodeClass a kept server-side NodePool references is deleted
+// without being uploaded again.
+func checkReferences(nodepools []RebalanceNodePool, nodeclasses []RebalanceNodeClass, kept serverKept) refReport {
+	var report refReport
+
+	available := make(map[string]RebalanceNodeClass, len(nodeclasses)+len(kept.NodeClasses))
+	for _, nc := range kept.NodeClasses {
+		available[nc.GetName()] = nc
+	}
+	for _, nc := range nodeclasses {
+		available[nc.GetName()] = nc
+	}
+
+	referenced := make(map[string]struct{}, len(nodepools)+len(kept.NodePools))
+	for _, np := range nodepools {
+		group, kind, name, ok := np.NodeClassRef()
+		if !ok {
+			report.Dangling = append(report.Dangling, danglingRef{NodePool: np.GetName(), Reason: "no nodeClassRef"})
+			continue
+		}
+		referenced[name] = struct{}{}
+
+		ref := fmt.Sprintf("%s/%s/%s", group, kind, name)
+		wantGroup, wantKind := np.nodeClassGroupKind()
+		switch nc, found := available[name]; {
+		case group != wantGroup || kind != wantKind:
+			report.Dangling = append(report.Dangling, danglingRef{NodePool: np.GetName(), Ref: ref,
+				Reason: fmt.Sprintf("must reference group %q kind %q", wantGroup, wantKind)})
+		case !found:
+			report.Dangling = append(report.Dangling, danglingRef{NodePool: np.GetName(), Ref: ref,
+				Reason: "nodeclass is neither uploaded nor on the server"})
+		case nc.Kind() != kind:
+			report.Dangling = append(report.Dangling, danglingRef{NodePool: np.GetName(), Ref: ref,
+				Reason: fmt.Sprintf("nodeclass %q is a %s", name, nc.Kind())})
+		}
+	}
+
+	// Refs the server-side pools already had before the run are not checked,
+	// only those the run breaks
+	deleted := make(map[string]struct{}, len(kept.Deleted))
+	for _, name := range kept.Deleted {
+		deleted[name] = struct{}{}
+	}
+	for _, np := range kept.NodePools {
+		group, kind, name, ok := np.NodeClassRef()
+		if !ok {
+			continue
+		}
+		referenced[name] = struct{}{}
+		_, isDeleted := deleted[name]
+		if _, found := available[name]; isDeleted && !found {
+			report.Dangling = append(report.Dangling, danglingRef{NodePool: np.GetName(), Ref: fmt.Sprintf("%s/%s/%s", group, kind, name),
+				Reason: "server-side nodepool is kept but its nodeclass is deleted and not uploaded again"})
+		}
+	}
+
+	for _, nc := range nodeclasses {
+		if _, ok := referenced[nc.GetName()]; !ok {
+			report.Orphans = append(report.Orphans, nc.GetName())
+		}
+	}
+	return report
+}
+
+func printRefReport(r refReport) {
+	if len(r.Dangling) > 0 {
+		fmt.Println("\n=== Dangling nodeClassRefs ===")
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NODEPOOL\tNODECLASSREF\tREASON")
+		for _, d := range r.Dangling {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", d.NodePool, d.Ref, d.Reason)
+		}
+		w.Flush()
+	}
+	if len(r.Orphans) > 0 {
+		fmt.Println("\n=== NodeClasses not referenced by any NodePool ===")
+		for _, name := range r.Orphans {
+			fmt.Println(name)
+		}
+	}
+}
+
+// withoutNodePools returns nodepools minus the given names.
+func withoutNodePools(nodepools []RebalanceNodePool, names []string) []RebalanceNodePool {
+	skip := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		skip[name] = struct{}{}
+	}
+	out := make([]RebalanceNodePool, 0, len(nodepools))
+	for _, np := range nodepools {
+		if _, ok := skip[np.GetName()]; !ok {
+			out = append(out, np)
+		}
+	}
+	return out
+}
+
+// withoutNodeClasses returns nodeclasses minus the given names.
+func withoutNodeClasses(nodeclasses []RebalanceNodeClass, names []string) []RebalanceNodeClass {
+	skip := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		skip[name] = struct{}{}
+	}
+	out := make([]RebalanceNodeClass, 0, len(nodeclasses))
+	for _, nc := range nodeclasses {
+		if _, ok := skip[nc.GetName()]; !ok {
+			out = append(out, nc)
+		}
+	}
+	return out
+}
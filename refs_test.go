@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckReferences(t *testing.T) {
+	noRef := testNodePool("noref", "")
+	noRef.ECSNodePool.NodePoolSpec.Template.Spec.NodeClassRef = nil
+	wrongGroup := testNodePool("wronggroup", "x")
+	wrongGroup.ECSNodePool.NodePoolSpec.Template.Spec.NodeClassRef.Group = "karpenter.k8s.aws"
+
+	tests := []struct {
+		name        string
+		nodepools   []RebalanceNodePool
+		nodeclasses []RebalanceNodeClass
+		kept        serverKept
+		// dangling are substrings of the expected reasons, one per NodePool
+		dangling []string
+		orphans  []string
+	}{
+		{
+			name:        "class uploaded",
+			nodepools:   []RebalanceNodePool{testNodePool("a", "x")},
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+		},
+		{
+			name:      "class kept on the server",
+			nodepools: []RebalanceNodePool{testNodePool("a", "x")},
+			kept:      serverKept{NodeClasses: []RebalanceNodeClass{testNodeClass("x")}},
+		},
+		{
+			name:        "class missing",
+			nodepools:   []RebalanceNodePool{testNodePool("a", "missing")},
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+			dangling:    []string{"neither uploaded nor on the server"},
+			orphans:     []string{"x"},
+		},
+		{
+			name:      "no nodeClassRef",
+			nodepools: []RebalanceNodePool{noRef},
+			dangling:  []string{"no nodeClassRef"},
+		},
+		{
+			name:        "wrong group",
+			nodepools:   []RebalanceNodePool{wrongGroup},
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+			dangling:    []string{"must reference group"},
+		},
+		{
+			name:        "class of another kind",
+			nodepools:   []RebalanceNodePool{testNodePool("a", "x")},
+			nodeclasses: []RebalanceNodeClass{{EC2NodeClass: &EC2NodeClass{Name: "x"}}},
+			dangling:    []string{`nodeclass "x" is a EC2NodeClass`},
+		},
+		{
+			name:        "orphan class",
+			nodepools:   []RebalanceNodePool{testNodePool("a", "x")},
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x"), testNodeClass("orphan")},
+			orphans:     []string{"orphan"},
+		},
+		{
+			name:        "class only a kept server-side pool references",
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+			kept:        serverKept{NodePools: []RebalanceNodePool{testNodePool("b", "x")}},
+		},
+		{
+			name:     "class of a kept server-side pool deleted",
+			kept:     serverKept{NodePools: []RebalanceNodePool{testNodePool("b", "x")}, Deleted: []string{"x"}},
+			dangling: []string{"server-side nodepool is kept but its nodeclass is deleted"},
+		},
+		{
+			name:        "class of a kept server-side pool deleted and uploaded again",
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+			kept:        serverKept{NodePools: []RebalanceNodePool{testNodePool("b", "x")}, Deleted: []string{"x"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := checkReferences(tt.nodepools, tt.nodeclasses, tt.kept)
+			if len(report.Dangling) != len(tt.dangling) {
+				t.Fatalf("dangling = %+v, want %d", report.Dangling, len(tt.dangling))
+			}
+			for i, want := range tt.dangling {
+				if !strings.Contains(report.Dangling[i].Reason, want) {
+					t.Errorf("dangling %d is %q, want it to contain %q", i, report.Dangling[i].Reason, want)
+				}
+			}
+			if !reflect.DeepEqual(report.Orphans, tt.orphans) {
+				t.Errorf("orphans = %v, want %v", report.Orphans, tt.orphans)
+			}
+		})
+	}
+}
+
+// Dangling references always stop the run, the orphan flags are the only
+// overrides.
+func TestMustCheckReferencesOrphanPolicy(t *testing.T) {
+	nodepools := []RebalanceNodePool{testNodePool("a", "x")}
+	nodeclasses := []RebalanceNodeClass{testNodeClass("x"), testNodeClass("orphan")}
+	for _, tt := range []struct {
+		name   string
+		policy orphanPolicy
+		want   []string
+	}{
+		{"--include-orphans", orphanPolicy{include: true}, []string{"x", "orphan"}},
+		{"--skip-orphans", orphanPolicy{skip: true}, []string{"x"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nodeClassNames(mustCheckReferences(nodepools, nodeclasses, serverKept{}, tt.policy))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uploaded NodeClasses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
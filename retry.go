@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"k8s.io/klog"
+)
+
+// idempotentKey marks a request context as safe to send more than once.
+type idempotentKey struct{}
+
+// withIdempotent marks the requests made with ctx as safe to repeat, e.g. an
+// apply that upserts by name.
+func withIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	ok, _ := ctx.Value(idempotentKey{}).(bool)
+	return ok
+}
+
+// checkRetry retries connection errors, 429 and 5xx like the default policy,
+// but never another 4xx, and never a request that is not safe to repeat: a
+// POST that timed out may have been applied already.
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	retry, checkErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if !retry {
+		return false, checkErr
+	}
+	if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return false, nil
+	}
+	if !isIdempotent(ctx) {
+		klog.Warningf("not retrying, the request is not safe to repeat")
+		return false, checkErr
+	}
+	return true, nil
+}
+
+// jitterBackoff is the default exponential backoff with every wait spread by
+// up to ±jitter of itself, so clients that failed together don't retry
+// together. A Retry-After on 429/503 is honored as it is.
+func jitterBackoff(jitter float64) retryablehttp.Backoff {
+	return func(waitMin, waitMax time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		wait := retryablehttp.DefaultBackoff(waitMin, waitMax, attemptNum, resp)
+		if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) &&
+			resp.Header.Get("Retry-After") != "" {
+			return wait
+		}
+		if jitter <= 0 {
+			return wait
+		}
+		spread := time.Duration(float64(wait) * jitter * (2*rand.Float64() - 1))
+		return min(wait+spread, waitMax)
+	}
+}
+
+// logRetry logs every retry with its attempt number.
+func logRetry(maxAttempts int) retryablehttp.RequestLogHook {
+	return func(_ retryablehttp.Logger, req *http.Request, retryNumber int) {
+		if retryNumber > 0 {
+			klog.Warningf("retrying %s %s, attempt %d of %d", req.Method, req.URL, retryNumber+1, maxAttempts)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckRetry(t *testing.T) {
+	connErr := errors.New("connection refused")
+	tests := []struct {
+		name       string
+		idempotent bool
+		status     int
+		err        error
+		want       bool
+	}{
+		{name: "ok", idempotent: true, status: http.StatusOK},
+		{name: "bad request", idempotent: true, status: http.StatusBadRequest},
+		{name: "not found", idempotent: true, status: http.StatusNotFound},
+		{name: "conflict", idempotent: true, status: http.StatusConflict},
+		{name: "too many requests", idempotent: true, status: http.StatusTooManyRequests, want: true},
+		{name: "server error", idempotent: true, status: http.StatusInternalServerError, want: true},
+		{name: "server error, not safe to repeat", status: http.StatusInternalServerError},
+		{name: "connection error", idempotent: true, err: connErr, want: true},
+		{name: "connection error, not safe to repeat", err: connErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.idempotent {
+				ctx = withIdempotent(ctx)
+			}
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			}
+			got, _ := checkRetry(ctx, resp, tt.err)
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJitterBackoff(t *testing.T) {
+	const waitMin, waitMax = 100 * time.Millisecond, time.Second
+
+	t.Run("no jitter", func(t *testing.T) {
+		backoff := jitterBackoff(0)
+		for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+			if got := backoff(waitMin, waitMax, attempt, nil); got != want*time.Millisecond {
+				t.Errorf("attempt %d: got %s, want %s", attempt, got, want*time.Millisecond)
+			}
+		}
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		backoff := jitterBackoff(0.5)
+		for i := 0; i < 1000; i++ {
+			if got := backoff(waitMin, waitMax, 2, nil); got < 200*time.Millisecond || got > 600*time.Millisecond {
+				t.Fatalf("attempt 2: got %s, want 400ms ± 50%%", got)
+			}
+			if got := backoff(waitMin, waitMax, 10, nil); got < waitMax/2 || got > waitMax {
+				t.Fatalf("attempt 10: got %s, want between %s and %s", got, waitMax/2, waitMax)
+			}
+		}
+	})
+
+	t.Run("retry-after", func(t *testing.T) {
+		backoff := jitterBackoff(0.5)
+		for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+			resp := &http.Response{StatusCode: status, Header: http.Header{"Retry-After": []string{"3"}}}
+			if got := backoff(waitMin, waitMax, 0, resp); got != 3*time.Second {
+				t.Errorf("%d: got %s, want the 3s of Retry-After", status, got)
+			}
+		}
+	})
+}
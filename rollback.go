@@ -0,0 +1,31 @@
+package main
+
+// rollbackSteps undoes a failed migration: objects uploaded by this run that did
+// not exist before are removed, then the pre-migration snapshot is re-applied.
+// The rollback is best-effort: a failed step only skips the steps that depend
+// on it, so that as much of the previous state as possible comes back.
+func rollbackSteps(c *Client, done uploadedObjects, snapshot serverSnapshot) []step {
+	previousPools := make(map[string]struct{}, len(snapshot.NodePools))
+	for _, np := range snapshot.NodePools {
+		previousPools[np.GetName()] = struct{}{}
+	}
+	previousClasses := make(map[string]struct{}, len(snapshot.NodeClasses))
+	for _, nc := range snapshot.NodeClasses {
+		previousClasses[nc.GetName()] = struct{}{}
+	}
+
+	// Objects that existed before are overwritten by the re-apply below, so
+	// only the new ones are deleted. NodePools go first as they reference classes.
+	var steps []step
+	for _, name := range done.NodePools {
+		if _, ok := previousPools[name]; !ok {
+			steps = append(steps, deleteNodePoolStep(c, name))
+		}
+	}
+	for _, name := range done.NodeClasses {
+		if _, ok := previousClasses[name]; !ok {
+			steps = append(steps, deleteNodeClassStep(c, name, nil))
+		}
+	}
+	return append(steps, restoreSteps(c, snapshot)...)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// stringsFlag is a repeatable, comma-separated flag value.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f = append(*f, s)
+		}
+	}
+	return nil
+}
+
+// selection narrows a run down to some NodePools and NodeClasses.
+//
+// Once --nodepool or --nodeclass is given, a kind without its own globs is not
+// selected directly; NodeClasses referenced by selected NodePools are always
+// pulled in. --selector and --exclude apply on top of that, and --exclude wins
+// over everything.
+type selection struct {
+	NodePools   []string
+	NodeClasses []string
+	Exclude     []string
+	// Selector matches cluster object labels, nil selects everything.
+	Selector labels.Selector
+}
+
+func (s selection) byName() bool {
+	return len(s.NodePools) > 0 || len(s.NodeClasses) > 0
+}
+
+func (s selection) excluded(name string) bool {
+	return matchAny(s.Exclude, name)
+}
+
+func (s selection) matches(globs []string, name string) bool {
+	if s.excluded(name) {
+		return false
+	}
+	if s.byName() && !matchAny(globs, name) {
+		return false
+	}
+	return true
+}
+
+// filterCluster selects cluster objects by name and labels, then adds the
+// NodeClasses the selected NodePools reference.
+func (s selection) filterCluster(
+	nodepools []RebalanceNodePool,
+	poolLabels []map[string]string,
+	nodeclasses []RebalanceNodeClass,
+	classLabels []map[string]string,
+) ([]RebalanceNodePool, []RebalanceNodeClass) {
+	var selectedPools []RebalanceNodePool
+	referenced := map[string]struct{}{}
+	for i, np := range nodepools {
+		if !s.matches(s.NodePools, np.GetName()) || (s.Selector != nil && !s.Selector.Matches(labels.Set(poolLabels[i]))) {
+			continue
+		}
+		selectedPools = append(selectedPools, np)
+		if _, _, name, ok := np.NodeClassRef(); ok {
+			referenced[name] = struct{}{}
+		}
+	}
+
+	var selectedClasses []RebalanceNodeClass
+	for i, nc := range nodeclasses {
+		name := nc.GetName()
+		_, isReferenced := referenced[name]
+		direct := s.matches(s.NodeClasses, name) && (s.Selector == nil || s.Selector.Matches(labels.Set(classLabels[i])))
+		if direct || (isReferenced && !s.excluded(name)) {
+			selectedClasses = append(selectedClasses, nc)
+		}
+	}
+	return selectedPools, selectedClasses
+}
+
+// filterServer narrows the server-side objects down to the ones this run may
+// delete or prune. Server objects carry no labels, so with --selector only the
+// ones whose cluster counterpart was selected are in scope.
+func (s selection) filterServer(
+	serverNodePools []RebalanceNodePool,
+	serverNodeClasses []RebalanceNodeClass,
+	nodepools []RebalanceNodePool,
+	nodeclasses []RebalanceNodeClass,
+) ([]RebalanceNodePool, []RebalanceNodeClass) {
+	selectedPools := make(map[string]struct{}, len(nodepools))
+	for _, np := range nodepools {
+		selectedPools[np.GetName()] = struct{}{}
+	}
+	selectedClasses := make(map[string]struct{}, len(nodeclasses))
+	for _, nc := range nodeclasses {
+		selectedClasses[nc.GetName()] = struct{}{}
+	}
+
+	var pools []RebalanceNodePool
+	for _, np := range serverNodePools {
+		name := np.GetName()
+		if _, ok := selectedPools[name]; ok || (s.Selector == nil && s.matches(s.NodePools, name)) {
+			pools = append(pools, np)
+		}
+	}
+	var classes []RebalanceNodeClass
+	for _, nc := range serverNodeClasses {
+		name := nc.GetName()
+		if _, ok := selectedClasses[name]; ok || (s.Selector == nil && s.matches(s.NodeClasses, name)) {
+			classes = append(classes, nc)
+		}
+	}
+	return pools, classes
+}
+
+func nodePoolNames(nodepools []RebalanceNodePool) []string {
+	names := make([]string, 0, len(nodepools))
+	for _, np := range nodepools {
+		names = append(names, np.GetName())
+	}
+	return names
+}
+
+func nodeClassNames(nodeclasses []RebalanceNodeClass) []string {
+	names := make([]string, 0, len(nodeclasses))
+	for _, nc := range nodeclasses {
+		names = append(names, nc.GetName())
+	}
+	return names
+}
+
+// checkGlobs returns the first glob that path.Match can't parse. Globs are
+// checked when the flags are read, so matchAny can't mistake a bad one for
+// one that matches nothing.
+func checkGlobs(globs []string) error {
+	for _, g := range globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("%q: %w", g, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func mustParseSelector(t *testing.T, s string) labels.Selector {
+	t.Helper()
+	selector, err := labels.Parse(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return selector
+}
+
+func TestFilterCluster(t *testing.T) {
+	nodepools := []RebalanceNodePool{testNodePool("a", "x"), testNodePool("b", "y"), testNodePool("web-1", "z")}
+	poolLabels := []map[string]string{{"team": "a"}, {"team": "b"}, nil}
+	nodeclasses := []RebalanceNodeClass{testNodeClass("x"), testNodeClass("y"), testNodeClass("z"), testNodeClass("w")}
+	classLabels := []map[string]string{nil, nil, nil, {"team": "a"}}
+
+	tests := []struct {
+		name     string
+		sel      selection
+		selector string
+		pools    string
+		classes  string
+	}{
+		{name: "everything", pools: "a,b,web-1", classes: "x,y,z,w"},
+		{name: "pool pulls in its class", sel: selection{NodePools: []string{"a"}}, pools: "a", classes: "x"},
+		{name: "pool glob", sel: selection{NodePools: []string{"web-*"}}, pools: "web-1", classes: "z"},
+		{name: "class only", sel: selection{NodeClasses: []string{"w"}}, classes: "w"},
+		{name: "exclude glob", sel: selection{Exclude: []string{"b", "w"}}, pools: "a,web-1", classes: "x,y,z"},
+		{name: "pool selected without its class", sel: selection{NodePools: []string{"a"}, Exclude: []string{"x"}}, pools: "a"},
+		{name: "exclude wins over the include glob", sel: selection{NodePools: []string{"*"}, Exclude: []string{"web-*"}}, pools: "a,b", classes: "x,y"},
+		{name: "label selector", selector: "team=a", pools: "a", classes: "x,w"},
+		{name: "label selector and name", sel: selection{NodePools: []string{"b"}}, selector: "team=a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel := tt.sel
+			if tt.selector != "" {
+				sel.Selector = mustParseSelector(t, tt.selector)
+			}
+			pools, classes := sel.filterCluster(nodepools, poolLabels, nodeclasses, classLabels)
+			if got := strings.Join(nodePoolNames(pools), ","); got != tt.pools {
+				t.Errorf("nodepools = %q, want %q", got, tt.pools)
+			}
+			if got := strings.Join(nodeClassNames(classes), ","); got != tt.classes {
+				t.Errorf("nodeclasses = %q, want %q", got, tt.classes)
+			}
+		})
+	}
+}
+
+func TestFilterServer(t *testing.T) {
+	serverPools := []RebalanceNodePool{testNodePool("a", "x"), testNodePool("b", "y"), testNodePool("old", "old")}
+	serverClasses := []RebalanceNodeClass{testNodeClass("x"), testNodeClass("y"), testNodeClass("old")}
+
+	tests := []struct {
+		name     string
+		sel      selection
+		selector string
+		// selected from the cluster
+		nodepools   []RebalanceNodePool
+		nodeclasses []RebalanceNodeClass
+		pools       string
+		classes     string
+	}{
+		{
+			name:        "everything",
+			nodepools:   []RebalanceNodePool{testNodePool("a", "x")},
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+			pools:       "a,b,old",
+			classes:     "x,y,old",
+		},
+		{
+			name:        "by name",
+			sel:         selection{NodePools: []string{"a", "old"}},
+			nodepools:   []RebalanceNodePool{testNodePool("a", "x")},
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+			pools:       "a,old",
+			classes:     "x",
+		},
+		{
+			name:    "exclude",
+			sel:     selection{Exclude: []string{"o*"}},
+			pools:   "a,b",
+			classes: "x,y",
+		},
+		{
+			// server objects have no labels, only the counterparts of
+			// selected cluster objects are in scope
+			name:        "label selector",
+			selector:    "team=a",
+			nodepools:   []RebalanceNodePool{testNodePool("a", "x")},
+			nodeclasses: []RebalanceNodeClass{testNodeClass("x")},
+			pools:       "a",
+			classes:     "x",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel := tt.sel
+			if tt.selector != "" {
+				sel.Selector = mustParseSelector(t, tt.selector)
+			}
+			pools, classes := sel.filterServer(serverPools, serverClasses, tt.nodepools, tt.nodeclasses)
+			if got := strings.Join(nodePoolNames(pools), ","); got != tt.pools {
+				t.Errorf("nodepools = %q, want %q", got, tt.pools)
+			}
+			if got := strings.Join(nodeClassNames(classes), ","); got != tt.classes {
+				t.Errorf("nodeclasses = %q, want %q", got, tt.classes)
+			}
+		})
+	}
+}
+
+func TestCheckGlobs(t *testing.T) {
+	if err := checkGlobs([]string{"a", "web-*", "[ab]?"}); err != nil {
+		t.Errorf("valid globs: %v", err)
+	}
+	if err := checkGlobs([]string{"a", "web-["}); err == nil || !strings.Contains(err.Error(), "web-[") {
+		t.Errorf("err = %v, want one naming web-[", err)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+
+	"golang.org/x/sync/errgroup"
+	"k8s.io/klog"
+)
+
+// errInterrupted is returned by runSteps after SIGINT or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// step is one server-side change. Runs are built as a list of steps so that a
+// failed or interrupted run can tell exactly how far it got.
+type step struct {
+	Action string // "delete" or "apply"
+	Kind   string // "NodePool" or "NodeClass"
+	Name   string
+	// SpecHash identifies what an apply sends, so a resumed run repeats it if
+	// the object changed since.
+	SpecHash string
+	// Sent is the variant an apply sends, checked against the server afterwards.
+	Sent any
+	// After lists the steps, by String(), that must succeed before this one.
+	After []string
+	do    func(ctx context.Context) error
+}
+
+func (s step) String() string {
+	return stepKey(s.Action, s.Kind, s.Name)
+}
+
+func stepKey(action, kind, name string) string {
+	return fmt.Sprintf("%s %s %s", action, kind, name)
+}
+
+// stepError is a step that failed, as opposed to a run that stopped before it.
+type stepError struct {
+	Step step
+	Err  error
+}
+
+func (e *stepError) Error() string { return fmt.Sprintf("%s: %v", e.Step, e.Err) }
+
+func (e *stepError) Unwrap() error { return e.Err }
+
+type stepStatus int
+
+const (
+	stepNotProcessed stepStatus = iota
+	stepDone
+	stepFailed
+	stepSkipped
+)
+
+type stepResult struct {
+	Status stepStatus
+	Err    error
+	// Resumed is set when an earlier run of the journal did the step.
+	Resumed bool
+}
+
+func deleteNodePoolStep(c *Client, name string) step {
+	return step{Action: "delete", Kind: "NodePool", Name: name, do: func(ctx context.Context) error {
+		return c.DeleteClusterRebalanceNodePool(ctx, name)
+	}}
+}
+
+func deleteNodeClassStep(c *Client, name string, after []string) step {
+	return step{Action: "delete", Kind: "NodeClass", Name: name, After: after, do: func(ctx context.Context) error {
+		return c.DeleteClusterRebalanceNodeClass(ctx, name)
+	}}
+}
+
+func applyNodePoolStep(c *Client, np RebalanceNodePool, after []string) step {
+	return step{Action: "apply", Kind: "NodePool", Name: np.GetName(), SpecHash: specHash(np), Sent: np.object(), After: after, do: func(ctx context.Context) error {
+		return c.ApplyNodePool(ctx, np)
+	}}
+}
+
+func applyNodeClassStep(c *Client, nc RebalanceNodeClass) step {
+	return step{Action: "apply", Kind: "NodeClass", Name: nc.GetName(), SpecHash: specHash(nc), Sent: nc.object(), do: func(ctx context.Context) error {
+		return c.ApplyNodeClass(ctx, nc)
+	}}
+}
+
+// applyNodePoolAfter is the apply of the NodeClass np references, when that
+// NodeClass is applied in the same run.
+func applyNodePoolAfter(np RebalanceNodePool, applied map[string]struct{}) []string {
+	if _, _, name, ok := np.NodeClassRef(); ok {
+		if _, ok := applied[name]; ok {
+			return []string{stepKey("apply", "NodeClass", name)}
+		}
+	}
+	return nil
+}
+
+// deleteNodeClassAfter is the delete of every NodePool that references the
+// NodeClass named name.
+func deleteNodeClassAfter(name string, deleted []RebalanceNodePool) []string {
+	var after []string
+	for _, np := range deleted {
+		if _, _, ref, ok := np.NodeClassRef(); ok && ref == name {
+			after = append(after, stepKey("delete", "NodePool", np.GetName()))
+		}
+	}
+	return after
+}
+
+// deleteSteps removes exactly the given server-side objects, a NodeClass only
+// after the NodePools referencing it. Callers pass the lists they previewed,
+// so nothing unseen is deleted.
+func deleteSteps(c *Client, nodepools []RebalanceNodePool, nodeclasses []RebalanceNodeClass) []step {
+	var steps []step
+	for _, np := range nodepools {
+		steps = append(steps, deleteNodePoolStep(c, np.GetName()))
+	}
+	for _, nc := range nodeclasses {
+		steps = append(steps, deleteNodeClassStep(c, nc.GetName(), deleteNodeClassAfter(nc.GetName(), nodepools)))
+	}
+	return steps
+}
+
+// uploadSteps applies the given objects, a NodePool only after the NodeClass
+// it references so that it is valid.
+func uploadSteps(c *Client, nodeclasses []RebalanceNodeClass, nodepools []RebalanceNodePool) []step {
+	var steps []step
+	applied := make(map[string]struct{}, len(nodeclasses))
+	for _, nc := range nodeclasses {
+		steps = append(steps, applyNodeClassStep(c, nc))
+		applied[nc.GetName()] = struct{}{}
+	}
+	for _, np := range nodepools {
+		steps = append(steps, applyNodePoolStep(c, np, applyNodePoolAfter(np, applied)))
+	}
+	return steps
+}
+
+// runSteps runs the steps phase by phase: consecutive steps with the same
+// action and kind form a phase and run up to concurrency at a time, and a
+// phase starts once the one before it has finished. A failed step doesn't
+// stop the run; only the steps that depend on it are skipped, and all errors
+// are returned together.
+//
+// Every finished step is recorded in the journal, and steps the journal has as
+// done by an earlier run are not run again. j may be nil.
+//
+// A first SIGINT or SIGTERM, or the end of ctx, stops starting new steps but
+// in-flight steps are always finished; a second signal exits right away.
+func runSteps(ctx context.Context, steps []step, concurrency int, j *journal) ([]stepResult, error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	var interrupted atomic.Bool
+	finished := make(chan struct{})
+	defer func() {
+		close(finished)
+		signal.Stop(sigs)
+	}()
+	go func() {
+		select {
+		case sig := <-sigs:
+			interrupted.Store(true)
+			// back to the default handler, so the next signal terminates
+			signal.Stop(sigs)
+			klog.Warningf("received %s, stopping after the in-flight steps; send it again to exit immediately", sig)
+		case <-finished:
+		}
+	}()
+
+	results := make([]stepResult, len(steps))
+	index := make(map[string]int, len(steps))
+	for i, s := range steps {
+		index[s.String()] = i
+	}
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	for start := 0; start < len(steps); {
+		end := start + 1
+		for end < len(steps) && steps[end].Action == steps[start].Action && steps[end].Kind == steps[start].Kind {
+			end++
+		}
+
+		g := errgroup.Group{}
+		g.SetLimit(max(concurrency, 1))
+		for i := start; i < end; i++ {
+			if interrupted.Load() || ctx.Err() != nil {
+				break
+			}
+			s := steps[i]
+			if j.completed(s) {
+				results[i] = stepResult{Status: stepDone, Resumed: true}
+				continue
+			}
+			if dep := failedDependency(s, index, results); dep != "" {
+				results[i] = stepResult{Status: stepSkipped, Err: fmt.Errorf("%s did not succeed", dep)}
+				continue
+			}
+			g.Go(func() error {
+				// g.Go waits for a free slot, the run may have been stopped meanwhile
+				if interrupted.Load() || ctx.Err() != nil {
+					return nil
+				}
+				klog.Infof("%s", s)
+				err := s.do(ctx)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					results[i] = stepResult{Status: stepFailed, Err: err}
+					errs = append(errs, &stepError{Step: s, Err: err})
+				} else {
+					results[i] = stepResult{Status: stepDone}
+				}
+				if err := j.recordStep(s, results[i]); err != nil {
+					klog.Errorf("failed to record %s in the journal: %v", s, err)
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+
+		switch {
+		case interrupted.Load():
+			errs = append(errs, errInterrupted)
+		case ctx.Err() != nil:
+			errs = append(errs, ctx.Err())
+		default:
+			start = end
+			continue
+		}
+		break
+	}
+	return results, errors.Join(errs...)
+}
+
+// failedDependency returns the first dependency of s that didn't succeed, or
+// "" if there is none. Dependencies outside of the run don't count.
+func failedDependency(s step, index map[string]int, results []stepResult) string {
+	for _, dep := range s.After {
+		if i, ok := index[dep]; ok && results[i].Status != stepDone {
+			return dep
+		}
+	}
+	return ""
+}
+
+// uploadedBy returns the objects the run applied. Failed applies count too,
+// since a request that timed out may still have been applied; deleting one
+// that wasn't is harmless.
+func uploadedBy(steps []step, results []stepResult) uploadedObjects {
+	var done uploadedObjects
+	for i, s := range steps {
+		if s.Action != "apply" || (results[i].Status != stepDone && results[i].Status != stepFailed) {
+			continue
+		}
+		switch s.Kind {
+		case "NodePool":
+			done.NodePools = append(done.NodePools, s.Name)
+		case "NodeClass":
+			done.NodeClasses = append(done.NodeClasses, s.Name)
+		}
+	}
+	return done
+}
+
+// hasFailed reports whether a step with the given action failed.
+func hasFailed(steps []step, results []stepResult, action string) bool {
+	for i, s := range steps {
+		if s.Action == action && results[i].Status == stepFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// printStepReport lists every step of a run with its result under title, so
+// that it is clear which objects were processed and which were not.
+func printStepReport(title string, steps []step, results []stepResult) {
+	fmt.Printf("\n=== %s ===\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tKIND\tNAME\tRESULT")
+	counts := map[stepStatus]int{}
+	for i, s := range steps {
+		r := results[i]
+		counts[r.Status]++
+		var result string
+		switch r.Status {
+		case stepDone:
+			result = "done"
+			if r.Resumed {
+				result = "done by an earlier run"
+			}
+		case stepFailed:
+			result = fmt.Sprintf("FAILED: %v", r.Err)
+		case stepSkipped:
+			result = fmt.Sprintf("skipped: %v", r.Err)
+		default:
+			result = "not processed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Action, s.Kind, s.Name, result)
+	}
+	w.Flush()
+	fmt.Printf("\n%d done, %d failed, %d skipped, %d not processed\n",
+		counts[stepDone], counts[stepFailed], counts[stepSkipped], counts[stepNotProcessed])
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recorder is the do of test steps: it logs when each step starts and ends,
+// and fails the steps named in fail.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+	fail   map[string]bool
+}
+
+func (r *recorder) step(action, kind, name string, after ...string) step {
+	s := step{Action: action, Kind: kind, Name: name, After: after}
+	s.do = func(ctx context.Context) error {
+		r.log("start " + s.String())
+		time.Sleep(10 * time.Millisecond)
+		r.log("end " + s.String())
+		if r.fail[s.String()] {
+			return errors.New("boom")
+		}
+		return nil
+	}
+	return s
+}
+
+func (r *recorder) log(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func statuses(results []stepResult) []stepStatus {
+	out := make([]stepStatus, len(results))
+	for i, r := range results {
+		out[i] = r.Status
+	}
+	return out
+}
+
+func equalStatuses(a, b []stepStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRunStepsPhaseOrder(t *testing.T) {
+	r := &recorder{}
+	steps := []step{
+		r.step("delete", "NodePool", "a"),
+		r.step("delete", "NodePool", "b"),
+		r.step("delete", "NodePool", "c"),
+		r.step("delete", "NodeClass", "x", stepKey("delete", "NodePool", "a")),
+		r.step("delete", "NodeClass", "y"),
+		r.step("apply", "NodeClass", "x"),
+		r.step("apply", "NodePool", "a", stepKey("apply", "NodeClass", "x")),
+	}
+	results, err := runSteps(context.Background(), steps, 4, nil)
+	if err != nil {
+		t.Fatalf("runSteps: %v", err)
+	}
+	for i, res := range results {
+		if res.Status != stepDone {
+			t.Errorf("%s: status %d, want done", steps[i], res.Status)
+		}
+	}
+
+	// every step of a phase ends before the first step of the next one starts
+	phase := func(s string) int {
+		for i, st := range steps {
+			if s == "start "+st.String() || s == "end "+st.String() {
+				return []int{0, 0, 0, 1, 1, 2, 3}[i]
+			}
+		}
+		t.Fatalf("unknown event %q", s)
+		return 0
+	}
+	last := 0
+	for _, e := range r.events {
+		p := phase(e)
+		if p < last {
+			t.Fatalf("phase %d event %q after a phase %d event: %v", p, e, last, r.events)
+		}
+		last = p
+	}
+}
+
+func TestRunStepsSkipsDependentsOfFailedStep(t *testing.T) {
+	r := &recorder{fail: map[string]bool{stepKey("delete", "NodePool", "a"): true}}
+	steps := []step{
+		r.step("delete", "NodePool", "a"),
+		r.step("delete", "NodePool", "b"),
+		r.step("delete", "NodeClass", "x", stepKey("delete", "NodePool", "a")),
+		r.step("delete", "NodeClass", "y", stepKey("delete", "NodePool", "b")),
+		r.step("apply", "NodeClass", "x"),
+		// outside dependencies don't count
+		r.step("apply", "NodePool", "a", stepKey("apply", "NodeClass", "z")),
+	}
+	results, err := runSteps(context.Background(), steps, 3, nil)
+	var se *stepError
+	if !errors.As(err, &se) || se.Step.String() != stepKey("delete", "NodePool", "a") {
+		t.Fatalf("err = %v, want the stepError of delete NodePool a", err)
+	}
+	want := []stepStatus{stepFailed, stepDone, stepSkipped, stepDone, stepDone, stepDone}
+	if got := statuses(results); !equalStatuses(got, want) {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+}
+
+func TestRunStepsStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &recorder{}
+	steps := []step{
+		r.step("delete", "NodePool", "a"),
+		r.step("delete", "NodePool", "b"),
+		r.step("delete", "NodePool", "c"),
+		r.step("apply", "NodePool", "a"),
+	}
+	// stop the run while b is in flight
+	do := steps[1].do
+	steps[1].do = func(ctx context.Context) error {
+		cancel()
+		return do(ctx)
+	}
+	results, err := runSteps(ctx, steps, 1, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// the in-flight step is finished, nothing after it is started
+	want := []stepStatus{stepDone, stepDone, stepNotProcessed, stepNotProcessed}
+	if got := statuses(results); !equalStatuses(got, want) {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+}
+
+func TestRunStepsStopsOnSignal(t *testing.T) {
+	r := &recorder{}
+	steps := []step{
+		r.step("delete", "NodePool", "a"),
+		r.step("delete", "NodePool", "b"),
+		r.step("delete", "NodeClass", "x"),
+	}
+	do := steps[0].do
+	steps[0].do = func(ctx context.Context) error {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+			t.Errorf("kill: %v", err)
+		}
+		// give runSteps time to take the signal before the step ends
+		time.Sleep(200 * time.Millisecond)
+		return do(ctx)
+	}
+	results, err := runSteps(context.Background(), steps, 1, nil)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("err = %v, want errInterrupted", err)
+	}
+	want := []stepStatus{stepDone, stepNotProcessed, stepNotProcessed}
+	if got := statuses(results); !equalStatuses(got, want) {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+}
@@ -9,114 +9,80 @@ import (
 	"strings"
 	"text/tabwriter"
 
-	alibabacloudproviderv1alpha1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis/v1alpha1"
-	alibabacloudcorev1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1"
+	"golang.org/x/term"
 	"k8s.io/klog"
 )
 
-// deleteAll removes the exact listed NodeClasses and NodePools from the cluster.
-// Uses foreground propagation to ensure cascading; adjust if your CRDs need background.
-func deleteAll(c *Client) error {
-	nodepools, err := c.ListClusterRebalanceNodePools()
-	if err != nil {
-		return err
-	}
-	nodeclasses, err := c.ListClusterRebalanceNodeClasses()
-	if err != nil {
-		return err
-	}
+// uploadedObjects records the names a run applied before it stopped.
+type uploadedObjects struct {
+	NodePools   []string
+	NodeClasses []string
+}
 
-	// Delete NodePools
-	for i := range nodepools.ECSNodePools {
-		np := &nodepools.ECSNodePools[i]
-		klog.Infof("deleting nodepool: %s", np.Name)
-		if err := c.DeleteClusterRebalanceNodePool(np.Name); err != nil {
-			return fmt.Errorf("delete nodepool %q: %w", np.Name, err)
-		}
-	}
+// ---- Preview table & helpers ----
 
-	// Delete NodeClasses
-	for i := range nodeclasses.ECSNodeClasses {
-		nc := &nodeclasses.ECSNodeClasses[i]
-		klog.Infof("deleting nodeclass: %s", nc.Name)
-		if err := c.DeleteClusterRebalanceNodeClass(nc.Name); err != nil {
-			return fmt.Errorf("delete nodeclass %q: %w", nc.Name, err)
-		}
-	}
-	return nil
-}
+func printPreviewTables(
+	serverNodePools []RebalanceNodePool,
+	serverNodeClasses []RebalanceNodeClass,
+	nodepools []RebalanceNodePool,
+	nodeclasses []RebalanceNodeClass,
+) {
+	printNodePools("Server-side NodePools to DELETE", serverNodePools)
+	printNodeClasses("Server-side NodeClasses to DELETE", serverNodeClasses)
+	printNodePools("NodePools to UPLOAD", nodepools)
+	printNodeClasses("NodeClasses to UPLOAD", nodeclasses)
 
-func uploadAll(
-	c *Client,
-	nodeclasses []alibabacloudproviderv1alpha1.ECSNodeClass,
-	nodepools []alibabacloudcorev1.NodePool,
-) error {
-	// Upload NodeClasses
-	for i := range nodeclasses {
-		nc := &nodeclasses[i]
-		klog.Infof("uploading nodeclass: %s", nc.Name)
-		if err := c.ApplyNodeClass(RebalanceNodeClass{
-			ECSNodeClass: &ECSNodeClass{
-				Name:          nc.Name,
-				NodeClassSpec: &nc.Spec,
-			},
-		}); err != nil {
-			return fmt.Errorf("apply nodeclass %q: %w", nc.Name, err)
-		}
-	}
+	fmt.Printf("\nSummary: DELETE %d NodePool(s), %d NodeClass(es) on the server; UPLOAD %d NodePool(s), %d NodeClass(es)\n",
+		len(serverNodePools), len(serverNodeClasses), len(nodepools), len(nodeclasses))
+}
 
-	// Upload NodePools
-	for i := range nodepools {
-		np := &nodepools[i]
-		klog.Infof("uploading nodepool: %s", np.Name)
-		if err := c.ApplyNodePool(RebalanceNodePool{
-			ECSNodePool: &ECSNodePool{
-				Name:         np.Name,
-				Enable:       true,
-				NodePoolSpec: &np.Spec,
-			},
-		}); err != nil {
-			return fmt.Errorf("apply nodepool %q: %w", np.Name, err)
-		}
+// printTarget heads every preview, so operators see which cluster they are
+// about to change before they confirm.
+func printTarget(clusterID string, target kubeTarget) {
+	context := target.Context
+	if context == "" {
+		context = "(in-cluster config)"
 	}
-	return nil
+	fmt.Println("\n=== Target ===")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "CloudPilot AI cluster:\t%s\n", clusterID)
+	fmt.Fprintf(w, "Kubernetes API server:\t%s\n", target.Server)
+	fmt.Fprintf(w, "Kubeconfig context:\t%s\n", context)
+	w.Flush()
 }
 
-// ---- Preview table & helpers ----
-
-func printPreviewTables(
-	nodepools []alibabacloudcorev1.NodePool,
-	nodeclasses []alibabacloudproviderv1alpha1.ECSNodeClass,
-) {
+func printNodePools(title string, nodepools []RebalanceNodePool) {
 	// Stable sort
-	sort.Slice(nodepools, func(i, j int) bool { return nodepools[i].Name < nodepools[j].Name })
-	sort.Slice(nodeclasses, func(i, j int) bool { return nodeclasses[i].Name < nodeclasses[j].Name })
+	sort.Slice(nodepools, func(i, j int) bool { return nodepools[i].GetName() < nodepools[j].GetName() })
 
-	// NodePools
-	fmt.Println("\n=== NodePools Preview ===")
-	npw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
-	fmt.Fprintln(npw, "NAME\tSPEC (truncated)")
+	fmt.Printf("\n=== %s ===\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tENABLE\tSPEC (truncated)")
 	for _, np := range nodepools {
-		fmt.Fprintf(npw, "%s\t%s\n",
-			np.Name,
-			trim(compactJSON(np.Spec), 120),
+		fmt.Fprintf(w, "%s\t%t\t%s\n",
+			np.GetName(),
+			np.Enabled(),
+			trim(compactJSON(np.Spec()), 120),
 		)
 	}
-	npw.Flush()
+	w.Flush()
+}
+
+func printNodeClasses(title string, nodeclasses []RebalanceNodeClass) {
+	// Stable sort
+	sort.Slice(nodeclasses, func(i, j int) bool { return nodeclasses[i].GetName() < nodeclasses[j].GetName() })
 
-	// NodeClasses
-	fmt.Println("\n=== ECSNodeClasses Preview ===")
-	ncw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
-	fmt.Fprintln(ncw, "NAME\tSPEC (truncated)")
+	fmt.Printf("\n=== %s ===\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKIND\tSPEC (truncated)")
 	for _, nc := range nodeclasses {
-		fmt.Fprintf(ncw, "%s\t%s\n",
-			nc.Name,
-			trim(compactJSON(nc.Spec), 120),
+		fmt.Fprintf(w, "%s\t%s\t%s\n",
+			nc.GetName(),
+			nc.Kind(),
+			trim(compactJSON(nc.Spec()), 120),
 		)
 	}
-	ncw.Flush()
-
-	fmt.Printf("\nSummary: %d NodePool(s), %d NodeClass(es)\n", len(nodepools), len(nodeclasses))
+	w.Flush()
 }
 
 func compactJSON(v any) string {
@@ -134,6 +100,51 @@ func trim(s string, max int) string {
 	return s[:max-3] + "..."
 }
 
+// confirmation holds the answers given on the command line for runs without
+// a terminal.
+type confirmation struct {
+	ClusterID string
+	// Token is --confirm, it approves everything and must equal ClusterID.
+	Token     string
+	YesDelete bool
+	YesUpload bool
+}
+
+// confirm approves a run that deletes and/or uploads server-side objects. The
+// flags are checked first; without them it prompts for the expected token, or
+// exits with exitNotConfirmed when stdin is not a terminal.
+func (cf confirmation) confirm(prompt, expected string, deletes, uploads bool) bool {
+	if cf.Token != "" && cf.Token == cf.ClusterID {
+		klog.Infof("confirmed by --confirm=%s", cf.Token)
+		return true
+	}
+	var given, missing []string
+	if deletes {
+		if cf.YesDelete {
+			given = append(given, "--yes-delete")
+		} else {
+			missing = append(missing, "--yes-delete")
+		}
+	}
+	if uploads {
+		if cf.YesUpload {
+			given = append(given, "--yes-upload")
+		} else {
+			missing = append(missing, "--yes-upload")
+		}
+	}
+	if len(missing) == 0 {
+		if len(given) > 0 {
+			klog.Infof("confirmed by %s", strings.Join(given, " "))
+		}
+		return true
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		fatalf(exitNotConfirmed, "stdin is not a terminal and nothing was confirmed, pass %s or --confirm=<cluster-id>", strings.Join(missing, " "))
+	}
+	return requireExactInput(prompt, expected)
+}
+
 // requireExactInput prompts and returns true only if the exact expected (case-insensitive) token is entered.
 func requireExactInput(prompt, expected string) bool {
 	reader := bufio.NewReader(os.Stdin)
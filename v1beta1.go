@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	alibabacloudproviderapis "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis"
+	alibabacloudcorev1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1"
+	alibabacloudcorev1beta1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// alibabacloudCoreV1beta1 is karpenter.sh/v1beta1. The vendored package has no
+// SchemeBuilder, so newScheme registers its NodePool types by hand.
+var alibabacloudCoreV1beta1 = schema.GroupVersion{Group: alibabacloudcorev1.SchemeGroupVersion.Group, Version: "v1beta1"}
+
+// conversionIssue is a v1beta1 field that has no place in the uploaded v1 spec.
+type conversionIssue struct {
+	NodePool string
+	Field    string
+	Reason   string
+}
+
+// convertV1beta1NodePool converts a karpenter.sh/v1beta1 NodePool into v1 the
+// way Karpenter's conversion webhook does, except that no compatibility
+// annotations are written: the ECS upload has no annotation field. Everything
+// that does not survive in the v1 NodePoolSpec, which is all the server
+// receives, is returned as an issue.
+func convertV1beta1NodePool(in *alibabacloudcorev1beta1.NodePool) (*alibabacloudcorev1.NodePool, []conversionIssue) {
+	var issues []conversionIssue
+	report := func(field, reason string) {
+		issues = append(issues, conversionIssue{NodePool: in.Name, Field: field, Reason: reason})
+	}
+
+	out := &alibabacloudcorev1.NodePool{ObjectMeta: *in.ObjectMeta.DeepCopy()}
+	out.APIVersion = alibabacloudcorev1.SchemeGroupVersion.String()
+	out.Kind = "NodePool"
+
+	src := in.Spec.Template
+	dst := &out.Spec.Template
+	dst.Labels = src.Labels
+	dst.Annotations = src.Annotations
+	dst.Spec.Taints = src.Spec.Taints
+	dst.Spec.StartupTaints = src.Spec.StartupTaints
+	for _, r := range src.Spec.Requirements {
+		dst.Spec.Requirements = append(dst.Spec.Requirements, alibabacloudcorev1.NodeSelectorRequirementWithMinValues{
+			NodeSelectorRequirement: r.NodeSelectorRequirement,
+			MinValues:               r.MinValues,
+		})
+	}
+
+	if ref := src.Spec.NodeClassRef; ref != nil {
+		group := alibabacloudproviderapis.Group
+		if ref.APIVersion != "" {
+			gv, err := schema.ParseGroupVersion(ref.APIVersion)
+			if err != nil {
+				report("spec.template.spec.nodeClassRef.apiVersion", fmt.Sprintf("cannot parse %q: %v", ref.APIVersion, err))
+			} else {
+				group = gv.Group
+			}
+		}
+		kind := ref.Kind
+		if kind == "" {
+			kind = "ECSNodeClass"
+		}
+		dst.Spec.NodeClassRef = &alibabacloudcorev1.NodeClassReference{Kind: kind, Name: ref.Name, Group: group}
+	} else {
+		report("spec.template.spec.nodeClassRef", "not set, v1 requires a nodeClassRef")
+	}
+
+	if src.Spec.Kubelet != nil {
+		report("spec.template.spec.kubelet",
+			"v1 moved kubelet to the NodeClass, dropped; set spec.kubeletConfiguration on the referenced ECSNodeClass")
+	}
+	if len(src.Spec.Resources.Requests) > 0 {
+		report("spec.template.spec.resources", "v1 NodePool templates have no resources, dropped")
+	}
+
+	dst.Spec.ExpireAfter = alibabacloudcorev1.NillableDuration{
+		Duration: in.Spec.Disruption.ExpireAfter.Duration,
+		Raw:      in.Spec.Disruption.ExpireAfter.Raw,
+	}
+
+	switch in.Spec.Disruption.ConsolidationPolicy {
+	case alibabacloudcorev1beta1.ConsolidationPolicyWhenEmpty:
+		out.Spec.Disruption.ConsolidationPolicy = alibabacloudcorev1.ConsolidationPolicyWhenEmpty
+	case alibabacloudcorev1beta1.ConsolidationPolicyWhenUnderutilized, "":
+		out.Spec.Disruption.ConsolidationPolicy = alibabacloudcorev1.ConsolidationPolicyWhenEmptyOrUnderutilized
+	default:
+		report("spec.disruption.consolidationPolicy", fmt.Sprintf("unknown policy %q", in.Spec.Disruption.ConsolidationPolicy))
+	}
+	if after := in.Spec.Disruption.ConsolidateAfter; after != nil {
+		out.Spec.Disruption.ConsolidateAfter = alibabacloudcorev1.NillableDuration{Duration: after.Duration, Raw: after.Raw}
+	} else {
+		// v1beta1 WhenUnderutilized had no consolidateAfter, v1 requires one
+		out.Spec.Disruption.ConsolidateAfter = alibabacloudcorev1.MustParseNillableDuration("0s")
+	}
+	for _, b := range in.Spec.Disruption.Budgets {
+		out.Spec.Disruption.Budgets = append(out.Spec.Disruption.Budgets, alibabacloudcorev1.Budget{
+			Nodes:    b.Nodes,
+			Schedule: b.Schedule,
+			Duration: b.Duration,
+		})
+	}
+
+	out.Spec.Limits = alibabacloudcorev1.Limits(in.Spec.Limits)
+	out.Spec.Weight = in.Spec.Weight
+	return out, issues
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	alibabacloudproviderapis "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis"
+	alibabacloudcorev1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1"
+	alibabacloudcorev1beta1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1beta1"
+	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConvertV1beta1NodePoolKubelet(t *testing.T) {
+	in := &alibabacloudcorev1beta1.NodePool{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	in.Spec.Template.Spec.NodeClassRef = &alibabacloudcorev1beta1.NodeClassReference{
+		Kind:       "ECSNodeClass",
+		Name:       "default",
+		APIVersion: alibabacloudproviderapis.Group + "/v1alpha1",
+	}
+	in.Spec.Template.Spec.Kubelet = &alibabacloudcorev1beta1.KubeletConfiguration{MaxPods: lo.ToPtr(int32(64))}
+
+	out, issues := convertV1beta1NodePool(in)
+	if len(issues) != 1 || issues[0].Field != "spec.template.spec.kubelet" || !strings.Contains(issues[0].Reason, "dropped") {
+		t.Fatalf("issues = %+v, want the dropped kubelet", issues)
+	}
+	if len(out.Annotations) != 0 {
+		t.Errorf("annotations = %v, want none", out.Annotations)
+	}
+
+	payload, err := json.Marshal(desiredECSNodePool(out))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	for _, s := range []string{"kubelet", "maxPods", "annotation"} {
+		if strings.Contains(strings.ToLower(string(payload)), strings.ToLower(s)) {
+			t.Errorf("payload contains %q: %s", s, payload)
+		}
+	}
+	var got RebalanceNodePool
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := alibabacloudcorev1.NodeClassReference{Group: alibabacloudproviderapis.Group, Kind: "ECSNodeClass", Name: "default"}
+	if ref := got.ECSNodePool.NodePoolSpec.Template.Spec.NodeClassRef; ref == nil || *ref != want {
+		t.Errorf("nodeClassRef = %+v, want %+v", ref, want)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	alibabacloudproviderv1alpha1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis/v1alpha1"
+	"github.com/samber/lo"
+)
+
+// validationError is a spec that the ECSNodeClass CRD would reject.
+type validationError struct {
+	Name    string
+	Field   string
+	Message string
+}
+
+var (
+	// Kubebuilder patterns are not anchored, keep them that way to match the CRD.
+	vSwitchIDPattern       = regexp.MustCompile(`vsw-[0-9a-z]+`)
+	securityGroupIDPattern = regexp.MustCompile(`sg-[0-9a-z]+`)
+	resourceGroupIDPattern = regexp.MustCompile(`rg-[0-9a-z]+`)
+	imageAliasPattern      = regexp.MustCompile(`^[a-zA-Z0-9]*$`)
+
+	imageAliasFamilies        = []string{"AlibabaCloudLinux3", "ContainerOS"}
+	vSwitchSelectionPolicies  = []string{"balanced", "cheapest"}
+	reservedResourceKeys      = []string{"cpu", "memory", "ephemeral-storage", "pid"}
+	evictionSignals           = []string{"memory.available", "nodefs.available", "nodefs.inodesFree", "imagefs.available", "imagefs.inodesFree", "pid.available"}
+	systemDiskCategories      = []string{"cloud", "cloud_efficiency", "cloud_ssd", "cloud_essd", "cloud_auto", "cloud_essd_entry"}
+	systemDiskPerformanceLvls = []string{"PL0", "PL1", "PL2", "PL3"}
+)
+
+// validateNodeClasses checks every ECSNodeClass against a Go port of the CRD's
+// OpenAPI and CEL (XValidation) rules, so bad specs fail before upload.
+// EC2NodeClasses are not checked.
+func validateNodeClasses(nodeclasses []RebalanceNodeClass) []validationError {
+	var errs []validationError
+	for _, nc := range nodeclasses {
+		if nc.ECSNodeClass == nil || nc.ECSNodeClass.NodeClassSpec == nil {
+			continue
+		}
+		errs = append(errs, validateECSNodeClassSpec(nc.GetName(), nc.ECSNodeClass.NodeClassSpec)...)
+	}
+	return errs
+}
+
+func validateECSNodeClassSpec(name string, spec *alibabacloudproviderv1alpha1.ECSNodeClassSpec) []validationError {
+	var errs []validationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, validationError{Name: name, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// vSwitchSelectorTerms
+	vsw := spec.VSwitchSelectorTerms
+	if len(vsw) == 0 {
+		add("spec.vSwitchSelectorTerms", "vSwitchSelectorTerms cannot be empty")
+	}
+	if len(vsw) > 30 {
+		add("spec.vSwitchSelectorTerms", "must have at most 30 items")
+	}
+	if !lo.EveryBy(vsw, func(t alibabacloudproviderv1alpha1.VSwitchSelectorTerm) bool { return len(t.Tags) > 0 || t.ID != "" }) {
+		add("spec.vSwitchSelectorTerms", "expected at least one, got none, ['tags', 'id']")
+	}
+	if len(vsw) > 0 && lo.EveryBy(vsw, func(t alibabacloudproviderv1alpha1.VSwitchSelectorTerm) bool { return t.ID != "" && len(t.Tags) > 0 }) {
+		add("spec.vSwitchSelectorTerms", "'id' is mutually exclusive, cannot be set with a combination of other fields in vSwitchSelectorTerms")
+	}
+	for i, t := range vsw {
+		field := fmt.Sprintf("spec.vSwitchSelectorTerms[%d]", i)
+		validateSelectorTags(field+".tags", t.Tags, add)
+		if t.ID != "" && !vSwitchIDPattern.MatchString(t.ID) {
+			add(field+".id", "must match %q", vSwitchIDPattern)
+		}
+	}
+
+	if p := spec.VSwitchSelectionPolicy; p != "" && !lo.Contains(vSwitchSelectionPolicies, p) {
+		add("spec.vSwitchSelectionPolicy", "must be one of %v", vSwitchSelectionPolicies)
+	}
+
+	// securityGroupSelectorTerms
+	sg := spec.SecurityGroupSelectorTerms
+	if len(sg) == 0 {
+		add("spec.securityGroupSelectorTerms", "securityGroupSelectorTerms cannot be empty")
+	}
+	if len(sg) > 30 {
+		add("spec.securityGroupSelectorTerms", "must have at most 30 items")
+	}
+	if !lo.EveryBy(sg, func(t alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm) bool {
+		return len(t.Tags) > 0 || t.ID != "" || t.Name != ""
+	}) {
+		add("spec.securityGroupSelectorTerms", "expected at least one, got none, ['tags', 'id', 'name']")
+	}
+	if len(sg) > 0 && lo.EveryBy(sg, func(t alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm) bool {
+		return t.ID != "" && (len(t.Tags) > 0 || t.Name != "")
+	}) {
+		add("spec.securityGroupSelectorTerms", "'id' is mutually exclusive, cannot be set with a combination of other fields in securityGroupSelectorTerms")
+	}
+	if len(sg) > 0 && lo.EveryBy(sg, func(t alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm) bool {
+		return t.Name != "" && (len(t.Tags) > 0 || t.ID != "")
+	}) {
+		add("spec.securityGroupSelectorTerms", "'name' is mutually exclusive, cannot be set with a combination of other fields in securityGroupSelectorTerms")
+	}
+	for i, t := range sg {
+		field := fmt.Sprintf("spec.securityGroupSelectorTerms[%d]", i)
+		validateSelectorTags(field+".tags", t.Tags, add)
+		if t.ID != "" && !securityGroupIDPattern.MatchString(t.ID) {
+			add(field+".id", "must match %q", securityGroupIDPattern)
+		}
+	}
+
+	// imageSelectorTerms
+	img := spec.ImageSelectorTerms
+	if len(img) < 1 {
+		add("spec.imageSelectorTerms", "must have at least 1 item")
+	}
+	if len(img) > 30 {
+		add("spec.imageSelectorTerms", "must have at most 30 items")
+	}
+	if !lo.EveryBy(img, func(t alibabacloudproviderv1alpha1.ImageSelectorTerm) bool { return t.ID != "" || t.Alias != "" }) {
+		add("spec.imageSelectorTerms", "expected at least one, got none, ['id', 'alias']")
+	}
+	if lo.SomeBy(img, func(t alibabacloudproviderv1alpha1.ImageSelectorTerm) bool { return t.ID != "" && t.Alias != "" }) {
+		add("spec.imageSelectorTerms", "'id' is mutually exclusive, cannot be set with a combination of other fields in imageSelectorTerms")
+	}
+	if lo.SomeBy(img, func(t alibabacloudproviderv1alpha1.ImageSelectorTerm) bool { return t.Alias != "" }) && len(img) != 1 {
+		add("spec.imageSelectorTerms", "'alias' is mutually exclusive, cannot be set with a combination of other imageSelectorTerms")
+	}
+	for i, t := range img {
+		if t.Alias == "" {
+			continue
+		}
+		field := fmt.Sprintf("spec.imageSelectorTerms[%d].alias", i)
+		if len(t.Alias) > 30 {
+			add(field, "must be at most 30 characters")
+		}
+		if !imageAliasPattern.MatchString(t.Alias) {
+			add(field, "'alias' is improperly formatted, must match the format 'family'")
+		}
+		if family, _, _ := strings.Cut(t.Alias, "@"); !lo.Contains(imageAliasFamilies, family) {
+			add(field, "family is not supported, must be one of the following: 'AlibabaCloudLinux3,ContainerOS'")
+		}
+	}
+
+	if k := spec.KubeletConfiguration; k != nil {
+		validateKubelet(k, add)
+	}
+
+	if d := spec.SystemDisk; d != nil {
+		for i, c := range d.Categories {
+			if !lo.Contains(systemDiskCategories, c) {
+				add(fmt.Sprintf("spec.systemDisk.categories[%d]", i), "must be one of %v", systemDiskCategories)
+			}
+		}
+		if d.Size != nil && *d.Size < 20 {
+			add("spec.systemDisk.size", "size invalid")
+		}
+		if d.PerformanceLevel != nil && !lo.Contains(systemDiskPerformanceLvls, *d.PerformanceLevel) {
+			add("spec.systemDisk.performanceLevel", "must be one of %v", systemDiskPerformanceLvls)
+		}
+	}
+
+	for _, k := range sortedKeys(spec.Tags) {
+		switch {
+		case k == "":
+			add("spec.tags", "empty tag keys aren't supported")
+		case k == "ecs:ecs-cluster-name":
+			add("spec.tags", "tag contains a restricted tag matching ecs:ecs-cluster-name")
+		case strings.HasPrefix(k, "kubernetes.io/cluster"):
+			add("spec.tags", "tag contains a restricted tag matching kubernetes.io/cluster/")
+		case k == "karpenter.sh/nodepool":
+			add("spec.tags", "tag contains a restricted tag matching karpenter.sh/nodepool")
+		case k == "karpenter.sh/nodeclaim":
+			add("spec.tags", "tag contains a restricted tag matching karpenter.sh/nodeclaim")
+		case k == "karpenter.k8s.alibabacloud/ecsnodeclass":
+			add("spec.tags", "tag contains a restricted tag matching karpenter.k8s.alibabacloud/ecsnodeclass")
+		}
+	}
+
+	if id := spec.ResourceGroupID; id != "" && !resourceGroupIDPattern.MatchString(id) {
+		add("spec.resourceGroupId", "must match %q", resourceGroupIDPattern)
+	}
+	return errs
+}
+
+func validateSelectorTags(field string, tags map[string]string, add func(field, format string, args ...any)) {
+	if _, ok := tags[""]; ok {
+		add(field, "empty tag keys aren't supported")
+	}
+	if len(tags) > 20 {
+		add(field, "must have at most 20 properties")
+	}
+}
+
+func validateKubelet(k *alibabacloudproviderv1alpha1.KubeletConfiguration, add func(field, format string, args ...any)) {
+	const field = "spec.kubeletConfiguration"
+
+	if k.MaxPods != nil && *k.MaxPods < 0 {
+		add(field+".maxPods", "must be greater than or equal to 0")
+	}
+	if k.PodsPerCore != nil && *k.PodsPerCore < 0 {
+		add(field+".podsPerCore", "must be greater than or equal to 0")
+	}
+	for _, r := range []struct {
+		name     string
+		reserved map[string]string
+	}{{"systemReserved", k.SystemReserved}, {"kubeReserved", k.KubeReserved}} {
+		for _, key := range sortedKeys(r.reserved) {
+			if !lo.Contains(reservedResourceKeys, key) {
+				add(field+"."+r.name, "valid keys for %s are ['cpu','memory','ephemeral-storage','pid']", r.name)
+			}
+			if strings.HasPrefix(r.reserved[key], "-") {
+				add(field+"."+r.name, "%s value cannot be a negative resource quantity", r.name)
+			}
+		}
+	}
+	for _, key := range sortedKeys(k.EvictionHard) {
+		if !lo.Contains(evictionSignals, key) {
+			add(field+".evictionHard", "valid keys for evictionHard are %v", evictionSignals)
+		}
+	}
+	for _, key := range sortedKeys(k.EvictionSoft) {
+		if !lo.Contains(evictionSignals, key) {
+			add(field+".evictionSoft", "valid keys for evictionSoft are %v", evictionSignals)
+		}
+		if _, ok := k.EvictionSoftGracePeriod[key]; !ok {
+			add(field, "evictionSoft OwnerKey does not have a matching evictionSoftGracePeriod")
+		}
+	}
+	for _, key := range sortedKeys(k.EvictionSoftGracePeriod) {
+		if !lo.Contains(evictionSignals, key) {
+			add(field+".evictionSoftGracePeriod", "valid keys for evictionSoftGracePeriod are %v", evictionSignals)
+		}
+		if _, ok := k.EvictionSoft[key]; !ok {
+			add(field, "evictionSoftGracePeriod OwnerKey does not have a matching evictionSoft")
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value *int32
+	}{{"imageGCHighThresholdPercent", k.ImageGCHighThresholdPercent}, {"imageGCLowThresholdPercent", k.ImageGCLowThresholdPercent}} {
+		if p.value != nil && (*p.value < 0 || *p.value > 100) {
+			add(field+"."+p.name, "must be between 0 and 100")
+		}
+	}
+	if high, low := k.ImageGCHighThresholdPercent, k.ImageGCLowThresholdPercent; high != nil && low != nil && *high <= *low {
+		add(field, "imageGCHighThresholdPercent must be greater than imageGCLowThresholdPercent")
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printValidationErrors(errs []validationError) {
+	fmt.Println("\n=== NodeClass validation errors ===")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFIELD\tERROR")
+	for _, e := range errs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Name, e.Field, e.Message)
+	}
+	w.Flush()
+}
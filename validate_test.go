@@ -0,0 +1,202 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	alibabacloudproviderv1alpha1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter-provider-alibabacloud/apis/v1alpha1"
+	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func validECSNodeClassSpec() *alibabacloudproviderv1alpha1.ECSNodeClassSpec {
+	return &alibabacloudproviderv1alpha1.ECSNodeClassSpec{
+		VSwitchSelectorTerms:       []alibabacloudproviderv1alpha1.VSwitchSelectorTerm{{ID: "vsw-abc"}},
+		SecurityGroupSelectorTerms: []alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm{{Tags: map[string]string{"team": "a"}}},
+		ImageSelectorTerms:         []alibabacloudproviderv1alpha1.ImageSelectorTerm{{Alias: "AlibabaCloudLinux3"}},
+	}
+}
+
+func TestValidateECSNodeClassSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*alibabacloudproviderv1alpha1.ECSNodeClassSpec)
+		// want are substrings of the expected messages, one per error
+		want []string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*alibabacloudproviderv1alpha1.ECSNodeClassSpec) {},
+		},
+		{
+			name: "empty vSwitch terms",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.VSwitchSelectorTerms = nil
+			},
+			want: []string{"vSwitchSelectorTerms cannot be empty"},
+		},
+		{
+			name: "vSwitch term without id or tags",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.VSwitchSelectorTerms = []alibabacloudproviderv1alpha1.VSwitchSelectorTerm{{}}
+			},
+			want: []string{"expected at least one, got none, ['tags', 'id']"},
+		},
+		{
+			name: "vSwitch id with tags",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.VSwitchSelectorTerms = []alibabacloudproviderv1alpha1.VSwitchSelectorTerm{{ID: "vsw-abc", Tags: map[string]string{"a": "b"}}}
+			},
+			want: []string{"'id' is mutually exclusive"},
+		},
+		{
+			name: "security group id with name",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.SecurityGroupSelectorTerms = []alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm{{ID: "sg-abc", Name: "web"}}
+			},
+			want: []string{"'id' is mutually exclusive", "'name' is mutually exclusive"},
+		},
+		{
+			name: "security group name with tags",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.SecurityGroupSelectorTerms = []alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm{{Name: "web", Tags: map[string]string{"a": "b"}}}
+			},
+			want: []string{"'name' is mutually exclusive"},
+		},
+		{
+			name: "security group id in one term, name in another",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.SecurityGroupSelectorTerms = []alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm{{ID: "sg-abc"}, {Name: "web"}}
+			},
+		},
+		{
+			name: "empty selector tag key",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.SecurityGroupSelectorTerms = []alibabacloudproviderv1alpha1.SecurityGroupSelectorTerm{{Tags: map[string]string{"": "b"}}}
+			},
+			want: []string{"empty tag keys aren't supported"},
+		},
+		{
+			name: "alias with another image term",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.ImageSelectorTerms = append(s.ImageSelectorTerms, alibabacloudproviderv1alpha1.ImageSelectorTerm{ID: "m-abc"})
+			},
+			want: []string{"'alias' is mutually exclusive"},
+		},
+		{
+			name: "image id with alias",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.ImageSelectorTerms = []alibabacloudproviderv1alpha1.ImageSelectorTerm{{ID: "m-abc", Alias: "ContainerOS"}}
+			},
+			want: []string{"'id' is mutually exclusive"},
+		},
+		{
+			name: "several image ids",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.ImageSelectorTerms = []alibabacloudproviderv1alpha1.ImageSelectorTerm{{ID: "m-abc"}, {ID: "m-def"}}
+			},
+		},
+		{
+			name: "unsupported alias family",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.ImageSelectorTerms = []alibabacloudproviderv1alpha1.ImageSelectorTerm{{Alias: "Ubuntu"}}
+			},
+			want: []string{"family is not supported"},
+		},
+		{
+			name: "restricted tag keys",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.Tags = map[string]string{
+					"ecs:ecs-cluster-name":                    "x",
+					"kubernetes.io/cluster/c1":                "owned",
+					"karpenter.sh/nodepool":                   "x",
+					"karpenter.sh/nodeclaim":                  "x",
+					"karpenter.k8s.alibabacloud/ecsnodeclass": "x",
+					"example.com/team":                        "a",
+				}
+			},
+			want: []string{
+				"restricted tag matching ecs:ecs-cluster-name",
+				"restricted tag matching karpenter.k8s.alibabacloud/ecsnodeclass",
+				"restricted tag matching karpenter.sh/nodeclaim",
+				"restricted tag matching karpenter.sh/nodepool",
+				"restricted tag matching kubernetes.io/cluster/",
+			},
+		},
+		{
+			name: "reserved keys and negative quantities",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.KubeletConfiguration = &alibabacloudproviderv1alpha1.KubeletConfiguration{
+					SystemReserved: map[string]string{"cpu": "100m", "gpu": "1"},
+					KubeReserved:   map[string]string{"memory": "-1Gi"},
+				}
+			},
+			want: []string{
+				"valid keys for systemReserved are",
+				"kubeReserved value cannot be a negative resource quantity",
+			},
+		},
+		{
+			name: "evictionSoft without grace period",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.KubeletConfiguration = &alibabacloudproviderv1alpha1.KubeletConfiguration{
+					EvictionSoft: map[string]string{"memory.available": "5%"},
+				}
+			},
+			want: []string{"evictionSoft OwnerKey does not have a matching evictionSoftGracePeriod"},
+		},
+		{
+			name: "grace period without evictionSoft",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.KubeletConfiguration = &alibabacloudproviderv1alpha1.KubeletConfiguration{
+					EvictionSoftGracePeriod: map[string]metav1.Duration{"memory.available": {Duration: time.Minute}},
+				}
+			},
+			want: []string{"evictionSoftGracePeriod OwnerKey does not have a matching evictionSoft"},
+		},
+		{
+			name: "evictionSoft with grace period",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.KubeletConfiguration = &alibabacloudproviderv1alpha1.KubeletConfiguration{
+					EvictionSoft:            map[string]string{"memory.available": "5%"},
+					EvictionSoftGracePeriod: map[string]metav1.Duration{"memory.available": {Duration: time.Minute}},
+				}
+			},
+		},
+		{
+			name: "imageGC high equal to low",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.KubeletConfiguration = &alibabacloudproviderv1alpha1.KubeletConfiguration{
+					ImageGCHighThresholdPercent: lo.ToPtr(int32(80)),
+					ImageGCLowThresholdPercent:  lo.ToPtr(int32(80)),
+				}
+			},
+			want: []string{"imageGCHighThresholdPercent must be greater than imageGCLowThresholdPercent"},
+		},
+		{
+			name: "imageGC high above low",
+			mutate: func(s *alibabacloudproviderv1alpha1.ECSNodeClassSpec) {
+				s.KubeletConfiguration = &alibabacloudproviderv1alpha1.KubeletConfiguration{
+					ImageGCHighThresholdPercent: lo.ToPtr(int32(85)),
+					ImageGCLowThresholdPercent:  lo.ToPtr(int32(80)),
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := validECSNodeClassSpec()
+			tt.mutate(spec)
+			errs := validateECSNodeClassSpec("default", spec)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %d error(s) %+v, want %d", len(errs), errs, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(errs[i].Message, want) {
+					t.Errorf("error %d is %q, want it to contain %q", i, errs[i].Message, want)
+				}
+			}
+		})
+	}
+}
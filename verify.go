@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// verifyItem is one object whose server-side state is not what the run left
+// it in, or that only has fields defaulted by the server.
+type verifyItem struct {
+	Kind string
+	Name string
+	// Missing is set when the object should be on the server but isn't,
+	// Extra when it is on the server but shouldn't be.
+	Missing bool
+	Extra   bool
+	// Dropped are fields that were sent but not stored, Changed are stored
+	// with another value, Defaulted were not sent and filled in by the server.
+	Dropped   []fieldDiff
+	Changed   []fieldDiff
+	Defaulted []fieldDiff
+}
+
+// drift reports whether the server state is not what was expected. Defaulted
+// fields alone are not drift.
+func (it verifyItem) drift() bool {
+	return it.Missing || it.Extra || len(it.Dropped) > 0 || len(it.Changed) > 0
+}
+
+type verifyReport struct {
+	Items []verifyItem
+	// Checked is how many objects were compared, on either side.
+	Checked int
+}
+
+func (r verifyReport) drift() int {
+	n := 0
+	for _, it := range r.Items {
+		if it.drift() {
+			n++
+		}
+	}
+	return n
+}
+
+// expectedServer is what the server should store after the steps: the
+// snapshot taken before the run, less what was deleted, plus what was applied.
+func expectedServer(snapshot serverSnapshot, steps []step, results []stepResult) (pools, classes map[string]any) {
+	pools = make(map[string]any, len(snapshot.NodePools))
+	for _, np := range snapshot.NodePools {
+		pools[np.GetName()] = np.object()
+	}
+	classes = make(map[string]any, len(snapshot.NodeClasses))
+	for _, nc := range snapshot.NodeClasses {
+		classes[nc.GetName()] = nc.object()
+	}
+	for i, s := range steps {
+		if results[i].Status != stepDone {
+			continue
+		}
+		objects := pools
+		if s.Kind == "NodeClass" {
+			objects = classes
+		}
+		switch s.Action {
+		case "delete":
+			delete(objects, s.Name)
+		case "apply":
+			objects[s.Name] = s.Sent
+		}
+	}
+	return pools, classes
+}
+
+// verifyServer lists the server side again and compares it, field by field,
+// with what the run should have left there.
+func verifyServer(ctx context.Context, c *Client, snapshot serverSnapshot, steps []step, results []stepResult) (verifyReport, error) {
+	serverNodePools, err := c.ListClusterRebalanceNodePools(ctx)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	serverNodeClasses, err := c.ListClusterRebalanceNodeClasses(ctx)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	storedPools := make(map[string]any)
+	for _, np := range serverNodePools.Items() {
+		storedPools[np.GetName()] = np.object()
+	}
+	storedClasses := make(map[string]any)
+	for _, nc := range serverNodeClasses.Items() {
+		storedClasses[nc.GetName()] = nc.object()
+	}
+
+	pools, classes := expectedServer(snapshot, steps, results)
+	var r verifyReport
+	r.verifyObjects("NodeClass", classes, storedClasses)
+	r.verifyObjects("NodePool", pools, storedPools)
+	return r, nil
+}
+
+func (r *verifyReport) verifyObjects(kind string, expected, stored map[string]any) {
+	names := make(map[string]struct{}, len(expected)+len(stored))
+	for name := range expected {
+		names[name] = struct{}{}
+	}
+	for name := range stored {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	r.Checked += len(sorted)
+
+	for _, name := range sorted {
+		want, ok := expected[name]
+		got, onServer := stored[name]
+		it := verifyItem{Kind: kind, Name: name, Missing: ok && !onServer, Extra: !ok && onServer}
+		if ok && onServer {
+			var diffs []fieldDiff
+			// Empty and absent are the same on the wire, so they don't count
+			walkDiff("", pruneEmpty(normalizeJSON(got)), pruneEmpty(normalizeJSON(want)), &diffs)
+			for _, d := range diffs {
+				switch {
+				case d.Server == nil:
+					it.Dropped = append(it.Dropped, d)
+				case d.Cluster == nil:
+					it.Defaulted = append(it.Defaulted, d)
+				default:
+					it.Changed = append(it.Changed, d)
+				}
+			}
+		}
+		if it.drift() || len(it.Defaulted) > 0 {
+			r.Items = append(r.Items, it)
+		}
+	}
+}
+
+// pruneEmpty drops nulls, empty objects and empty arrays from map values.
+func pruneEmpty(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			e = pruneEmpty(e)
+			if isEmptyJSON(e) {
+				continue
+			}
+			out[k] = e
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = pruneEmpty(e)
+		}
+		return out
+	}
+	return v
+}
+
+func isEmptyJSON(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case map[string]any:
+		return len(v) == 0
+	case []any:
+		return len(v) == 0
+	}
+	return false
+}
+
+func printVerifyReport(r verifyReport) {
+	fmt.Println("\n=== Verification ===")
+	if len(r.Items) == 0 {
+		fmt.Printf("all %d object(s) on the server are as expected\n", r.Checked)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tRESULT")
+	for _, it := range r.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", it.Kind, it.Name, verifyResult(it))
+	}
+	w.Flush()
+
+	for _, it := range r.Items {
+		if len(it.Dropped)+len(it.Changed)+len(it.Defaulted) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s %s:\n", it.Kind, it.Name)
+		for _, d := range it.Dropped {
+			fmt.Printf("  dropped   %s: %s\n", d.Path, trim(compactJSON(d.Cluster), 120))
+		}
+		for _, d := range it.Changed {
+			fmt.Printf("  changed   %s: sent %s, stored %s\n", d.Path, trim(compactJSON(d.Cluster), 60), trim(compactJSON(d.Server), 60))
+		}
+		for _, d := range it.Defaulted {
+			fmt.Printf("  defaulted %s: %s\n", d.Path, trim(compactJSON(d.Server), 120))
+		}
+	}
+	fmt.Printf("\n%d of %d object(s) drifted\n", r.drift(), r.Checked)
+}
+
+func verifyResult(it verifyItem) string {
+	switch {
+	case it.Missing:
+		return "MISSING on the server"
+	case it.Extra:
+		return "UNEXPECTED on the server"
+	case it.drift():
+		return fmt.Sprintf("DRIFTED: %d dropped, %d changed field(s)", len(it.Dropped), len(it.Changed))
+	}
+	return fmt.Sprintf("ok, %d field(s) defaulted by the server", len(it.Defaulted))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerifyObjects(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]any
+		stored   map[string]any
+		want     []verifyItem
+		drift    int
+	}{
+		{
+			name:     "as expected",
+			expected: map[string]any{"a": map[string]any{"weight": 1}},
+			stored:   map[string]any{"a": map[string]any{"weight": 1}},
+		},
+		{
+			name:     "missing",
+			expected: map[string]any{"a": map[string]any{}},
+			want:     []verifyItem{{Kind: "NodePool", Name: "a", Missing: true}},
+			drift:    1,
+		},
+		{
+			name:   "extra",
+			stored: map[string]any{"a": map[string]any{}},
+			want:   []verifyItem{{Kind: "NodePool", Name: "a", Extra: true}},
+			drift:  1,
+		},
+		{
+			name:     "dropped field",
+			expected: map[string]any{"a": map[string]any{"weight": 1, "taints": []any{"x"}}},
+			stored:   map[string]any{"a": map[string]any{"weight": 1}},
+			want:     []verifyItem{{Kind: "NodePool", Name: "a", Dropped: []fieldDiff{{Path: "taints", Cluster: []any{"x"}}}}},
+			drift:    1,
+		},
+		{
+			name:     "changed field",
+			expected: map[string]any{"a": map[string]any{"weight": 1}},
+			stored:   map[string]any{"a": map[string]any{"weight": 2}},
+			want:     []verifyItem{{Kind: "NodePool", Name: "a", Changed: []fieldDiff{{Path: "weight", Server: 2.0, Cluster: 1.0}}}},
+			drift:    1,
+		},
+		{
+			// reported, but not drift
+			name:     "defaulted by the server",
+			expected: map[string]any{"a": map[string]any{"weight": 1}},
+			stored:   map[string]any{"a": map[string]any{"weight": 1, "enable": true}},
+			want:     []verifyItem{{Kind: "NodePool", Name: "a", Defaulted: []fieldDiff{{Path: "enable", Server: true}}}},
+		},
+		{
+			name:     "empty and absent are the same",
+			expected: map[string]any{"a": map[string]any{"labels": map[string]any{}, "taints": nil}},
+			stored:   map[string]any{"a": map[string]any{"taints": []any{}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r verifyReport
+			r.verifyObjects("NodePool", tt.expected, tt.stored)
+			if !reflect.DeepEqual(r.Items, tt.want) {
+				t.Errorf("got %+v, want %+v", r.Items, tt.want)
+			}
+			if r.drift() != tt.drift {
+				t.Errorf("drift = %d, want %d", r.drift(), tt.drift)
+			}
+		})
+	}
+}
+
+func TestExpectedServer(t *testing.T) {
+	snapshot := serverSnapshot{
+		NodePools:   []RebalanceNodePool{testNodePool("old", "x"), testNodePool("kept", "x")},
+		NodeClasses: []RebalanceNodeClass{testNodeClass("x")},
+	}
+	steps := []step{
+		deleteNodePoolStep(nil, "old"),
+		applyNodePoolStep(nil, testNodePool("new", "x"), nil),
+		applyNodePoolStep(nil, testNodePool("failed", "x"), nil),
+	}
+	results := []stepResult{{Status: stepDone}, {Status: stepDone}, {Status: stepFailed}}
+	pools, classes := expectedServer(snapshot, steps, results)
+	if got, want := sortedKeys(pools), []string{"kept", "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodepools = %v, want %v", got, want)
+	}
+	if got, want := sortedKeys(classes), []string{"x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeclasses = %v, want %v", got, want)
+	}
+}
+
+// Drift after a run that went through exits with exitVerifyFailed. mustVerify
+// exits, so it runs in a child process.
+func TestMustVerifyDriftExits(t *testing.T) {
+	if os.Getenv("TEST_MUST_VERIFY") == "1" {
+		stored := testNodePool("a", "x")
+		stored.ECSNodePool.Enable = false
+		c := newTestClient(t, ClientOptions{}, func(w http.ResponseWriter, r *http.Request) {
+			data := any(RebalanceNodeClassList{ECSNodeClasses: []ECSNodeClass{*testNodeClass("x").ECSNodeClass}})
+			if strings.HasSuffix(r.URL.Path, "/nodepools") {
+				data = RebalanceNodePoolList{ECSNodePools: []ECSNodePool{*stored.ECSNodePool}}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"code": 0, "data": data})
+		})
+		steps := []step{applyNodeClassStep(c, testNodeClass("x")), applyNodePoolStep(c, testNodePool("a", "x"), nil)}
+		results := []stepResult{{Status: stepDone}, {Status: stepDone}}
+		mustVerify(t.Context(), c, &runState{what: "migration", backupPath: "backup.yaml"}, steps, results)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMustVerifyDriftExits$")
+	cmd.Env = append(os.Environ(), "TEST_MUST_VERIFY=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitVerifyFailed {
+		t.Fatalf("got %v, want exit code %d; output:\n%s", err, exitVerifyFailed, out)
+	}
+	for _, want := range []string{"changed   enable: sent true, stored false", "1 object(s) on the server are not as expected"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/cloudpilot-client/api"
+	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	workloadDeployment  = "Deployment"
+	workloadStatefulSet = "StatefulSet"
+)
+
+// workloadBundle is the reviewable file workloads scan writes, in the shape
+// of api.WorkloadRebalanceConfiguration.
+type workloadBundle struct {
+	ClusterID string             `json:"clusterID,omitempty"`
+	Workloads api.WorkloadsSlice `json:"workloads"`
+}
+
+func workloadKey(w api.Workload) string {
+	return api.GenerateWorkloadKey(w.Name, w.Type, w.Namespace)
+}
+
+func sortWorkloads(ws api.WorkloadsSlice) {
+	sort.Slice(ws, func(i, j int) bool { return workloadKey(ws[i]) < workloadKey(ws[j]) })
+}
+
+// workloadFilter narrows a scan down by namespace globs and workload labels.
+type workloadFilter struct {
+	Namespaces []string
+	Exclude    []string
+	// Selector matches workload labels, nil selects everything.
+	Selector labels.Selector
+}
+
+func (f workloadFilter) matches(namespace string, lbls map[string]string) bool {
+	if len(f.Namespaces) > 0 && !matchAny(f.Namespaces, namespace) {
+		return false
+	}
+	if matchAny(f.Exclude, namespace) {
+		return false
+	}
+	return f.Selector == nil || f.Selector.Matches(labels.Set(lbls))
+}
+
+// proposedWorkload is the config the agent would derive for a workload: the
+// spot-friendly and min-nonspot labels of the workload, and the
+// do-not-disrupt annotation of its pod template.
+func proposedWorkload(name, typ, namespace string, replicas *int32, lbls, podAnnotations map[string]string) api.Workload {
+	spotFriendly, minNonSpot := utils.ExtractWorkloadConfigFromLabels(lbls)
+	w := api.Workload{
+		Name:               name,
+		Type:               typ,
+		Namespace:          namespace,
+		Replicas:           1,
+		RebalanceAble:      utils.ExtractWorkloadRebalanceAbleFromAnnotations(podAnnotations),
+		SpotFriendly:       spotFriendly,
+		MinNonSpotReplicas: minNonSpot,
+	}
+	if replicas != nil {
+		w.Replicas = *replicas
+	}
+	return w
+}
+
+// scanWorkloads lists the Deployments and StatefulSets of the cluster and
+// proposes their rebalance config. It lists what the agent's
+// GetAllDeploymentAndStatefulSetWithLabels does, but with ctx, so --timeout
+// applies.
+func scanWorkloads(ctx context.Context, kubeClient client.Client, f workloadFilter) api.WorkloadsSlice {
+	deployments := &appsv1.DeploymentList{}
+	if err := kubeClient.List(ctx, deployments); err != nil {
+		fatalf(exitError, "failed to list deployments: %v", err)
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := kubeClient.List(ctx, statefulSets); err != nil {
+		fatalf(exitError, "failed to list statefulsets: %v", err)
+	}
+	var ws api.WorkloadsSlice
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		if f.matches(d.Namespace, d.Labels) {
+			ws = append(ws, proposedWorkload(d.Name, workloadDeployment, d.Namespace, d.Spec.Replicas, d.Labels, d.Spec.Template.Annotations))
+		}
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		if f.matches(s.Namespace, s.Labels) {
+			ws = append(ws, proposedWorkload(s.Name, workloadStatefulSet, s.Namespace, s.Spec.Replicas, s.Labels, s.Spec.Template.Annotations))
+		}
+	}
+	sortWorkloads(ws)
+	return ws
+}
+
+// writeWorkloads writes b as JSON if path ends in .json, as YAML otherwise.
+func writeWorkloads(path string, b workloadBundle) error {
+	sortWorkloads(b.Workloads)
+	data, err := marshalFile(path, b)
+	if err != nil {
+		return fmt.Errorf("marshal workloads: %w", err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func printWorkloads(title string, ws api.WorkloadsSlice) {
+	fmt.Printf("\n=== %s ===\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tREPLICAS\tREBALANCE-ABLE\tSPOT-FRIENDLY\tMIN-NONSPOT")
+	for _, wl := range ws {
+		fmt.Fprintf(w, "%s\t%d\t%t\t%t\t%d\n", workloadKey(wl), wl.Replicas, wl.RebalanceAble, wl.SpotFriendly, wl.MinNonSpotReplicas)
+	}
+	w.Flush()
+}
+
+// runWorkloadsScan proposes the rebalance config of the cluster workloads and
+// writes it to a file to review.
+func runWorkloadsScan(ctx context.Context, kubeClient client.Client, f workloadFilter, clusterID, out string) {
+	ws := scanWorkloads(ctx, kubeClient, f)
+	printWorkloads("Proposed workload rebalance config", ws)
+	if out == "" {
+		return
+	}
+	if err := writeWorkloads(out, workloadBundle{ClusterID: clusterID, Workloads: ws}); err != nil {
+		fatalf(exitError, "write workloads: %v", err)
+	}
+	klog.Infof("wrote %d workload(s) to %s", len(ws), out)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/cloudpilot-client/api"
+	"github.com/cloudpilot-ai/cloudpilot-agent/pkg/values"
+	alibabacloudcorev1beta1 "github.com/cloudpilot-ai/lib/pkg/alibabacloud/karpenter/apis/v1beta1"
+	"github.com/samber/lo"
+)
+
+func TestProposedWorkload(t *testing.T) {
+	tests := []struct {
+		name           string
+		replicas       *int32
+		labels         map[string]string
+		podAnnotations map[string]string
+		want           api.Workload
+	}{
+		{
+			name: "no labels or annotations",
+			want: api.Workload{Replicas: 1, RebalanceAble: true, SpotFriendly: true},
+		},
+		{
+			name:     "replicas",
+			replicas: lo.ToPtr(int32(5)),
+			want:     api.Workload{Replicas: 5, RebalanceAble: true, SpotFriendly: true},
+		},
+		{
+			name:     "not spot-friendly",
+			replicas: lo.ToPtr(int32(3)),
+			labels: map[string]string{
+				values.CloudPilotSpotFriendlyLabelKey:       "false",
+				values.CloudPilotMinNonSpotReplicasLabelKey: "2",
+			},
+			want: api.Workload{Replicas: 3, RebalanceAble: true},
+		},
+		{
+			name:     "min-nonspot",
+			replicas: lo.ToPtr(int32(3)),
+			labels:   map[string]string{values.CloudPilotMinNonSpotReplicasLabelKey: "2"},
+			want:     api.Workload{Replicas: 3, RebalanceAble: true, SpotFriendly: true, MinNonSpotReplicas: 2},
+		},
+		{
+			name:     "min-nonspot that doesn't parse",
+			replicas: lo.ToPtr(int32(3)),
+			labels:   map[string]string{values.CloudPilotMinNonSpotReplicasLabelKey: "two"},
+			want:     api.Workload{Replicas: 3, RebalanceAble: true, SpotFriendly: true},
+		},
+		{
+			name:           "do-not-disrupt pods",
+			podAnnotations: map[string]string{alibabacloudcorev1beta1.DoNotDisruptAnnotationKey: "true"},
+			want:           api.Workload{Replicas: 1, SpotFriendly: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Name, tt.want.Type, tt.want.Namespace = "web", workloadDeployment, "a"
+			got := proposedWorkload("web", workloadDeployment, "a", tt.replicas, tt.labels, tt.podAnnotations)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}